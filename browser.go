@@ -0,0 +1,267 @@
+// Copyright IBM Corp. 2014, 2026
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// minBrowseInterval is the delay before the second browse query. The
+	// interval then doubles after every query, as per section 5.2 of RFC 6762.
+	minBrowseInterval = time.Second
+
+	// maxBrowseInterval caps the interval between browse queries. Records
+	// are kept alive in between by refresh queries.
+	maxBrowseInterval = 60 * time.Minute
+)
+
+// BrowseEventType describes what happened to a service instance
+type BrowseEventType int
+
+const (
+	// BrowseAdded is emitted when an instance is first seen with all of its
+	// SRV, TXT and address records
+	BrowseAdded BrowseEventType = iota
+
+	// BrowseUpdated is emitted when the SRV, TXT or address data of a known
+	// instance changes
+	BrowseUpdated
+
+	// BrowseRemoved is emitted when an instance expires or says goodbye
+	BrowseRemoved
+)
+
+func (t BrowseEventType) String() string {
+	switch t {
+	case BrowseAdded:
+		return "added"
+	case BrowseUpdated:
+		return "updated"
+	case BrowseRemoved:
+		return "removed"
+	default:
+		return fmt.Sprintf("BrowseEventType(%d)", int(t))
+	}
+}
+
+// BrowseEvent is emitted by a Browser when a service instance changes
+type BrowseEvent struct {
+	Type  BrowseEventType
+	Entry *ServiceEntry
+}
+
+// Browser continuously looks up a given service, in a domain, and reports
+// instances as they appear, change and disappear. Unlike Query, a Browser
+// keeps running until its context is cancelled.
+type Browser struct {
+	params      *QueryParam
+	events      chan<- *BrowseEvent
+	serviceAddr string
+
+	// cache holds the records received
+	cache *recordCache
+
+	// entries holds the complete instances that have been reported
+	entries map[string]*ServiceEntry
+
+	// asked tracks when an incomplete instance was last queried directly
+	asked map[string]time.Time
+}
+
+// NewBrowser creates a Browser for the service described by params. The
+// Timeout and Entries fields of params are ignored; events are sent to the
+// given channel instead. Sends block until the event is read or the Browser
+// is stopped.
+func NewBrowser(params *QueryParam, events chan<- *BrowseEvent) *Browser {
+	return &Browser{
+		params:  params,
+		events:  events,
+		cache:   sharedCache,
+		entries: make(map[string]*ServiceEntry),
+		asked:   make(map[string]time.Time),
+	}
+}
+
+// Run browses until ctx is cancelled.
+func (b *Browser) Run(ctx context.Context) error {
+	params := b.params
+	if params.Logger == nil {
+		params.Logger = log.Default()
+	}
+	if params.Domain == "" {
+		params.Domain = "local"
+	}
+
+	// Create a new client
+	client, err := newClient(!params.DisableIPv4, !params.DisableIPv6, params.Logger)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	// Set the multicast interface
+	if params.Interface != nil {
+		if err := client.setInterface(params.Interface); err != nil {
+			return err
+		}
+	}
+
+	msgCh := make(chan *msgAddr, 32)
+	client.listen(msgCh)
+
+	b.serviceAddr = serviceName(params.Service, params.Subtype, params.Domain)
+	interval := minBrowseInterval
+	queryTimer := time.NewTimer(0)
+	defer queryTimer.Stop()
+	expiryTimer := time.NewTimer(maxBrowseInterval)
+	defer expiryTimer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case <-queryTimer.C:
+			m := new(dns.Msg)
+			m.SetQuestion(b.serviceAddr, dns.TypePTR)
+			if params.WantUnicastResponse {
+				m.Question[0].Qclass |= 1 << 15
+			}
+			m.RecursionDesired = false
+			if err := client.sendQuery(m); err != nil {
+				params.Logger.Printf("[ERR] mdns: Failed to browse %s: %v", b.serviceAddr, err)
+			}
+			queryTimer.Reset(interval)
+			interval = min(2*interval, maxBrowseInterval)
+			continue
+
+		case resp := <-msgCh:
+			b.addRecords(resp, time.Now())
+
+		case <-expiryTimer.C:
+		}
+
+		now := time.Now()
+		for _, q := range b.cache.refresh(now, b.interested) {
+			m := new(dns.Msg)
+			m.SetQuestion(q.Name, q.Qtype)
+			m.RecursionDesired = false
+			if err := client.sendQuery(m); err != nil {
+				params.Logger.Printf("[ERR] mdns: Failed to refresh %s: %v", q.Name, err)
+			}
+		}
+		events, incomplete := b.update(now)
+		for _, name := range incomplete {
+			// Fire off an instance specific query
+			m := new(dns.Msg)
+			m.SetQuestion(name, dns.TypeANY)
+			m.RecursionDesired = false
+			if err := client.sendQuery(m); err != nil {
+				params.Logger.Printf("[ERR] mdns: Failed to query instance %s: %v", name, err)
+			}
+		}
+		for _, e := range events {
+			select {
+			case b.events <- e:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+		expiryTimer.Reset(b.cache.next(now, maxBrowseInterval, b.interested))
+	}
+}
+
+// addRecords stores every record in the message in the cache
+func (b *Browser) addRecords(resp *msgAddr, now time.Time) {
+	for _, rr := range append(resp.msg.Answer, resp.msg.Extra...) {
+		b.cache.add(rr, resp.src, now)
+	}
+}
+
+// update evicts expired records and rebuilds the instances from the
+// remaining records. It returns an event for every difference from the last
+// update, along with the names of incomplete instances that should be queried
+// directly.
+func (b *Browser) update(now time.Time) ([]*BrowseEvent, []string) {
+	b.cache.expire(now)
+
+	current := make(map[string]*ServiceEntry)
+	var incomplete []string
+	for _, inp := range b.cache.serviceEntries(b.serviceAddr, now) {
+		if inp.complete() {
+			current[inp.Name] = inp
+			continue
+		}
+		if inp.noAddrs {
+			continue
+		}
+
+		// Ask for the missing records at most once a second
+		if last, ok := b.asked[inp.Name]; ok && now.Sub(last) < time.Second {
+			continue
+		}
+		b.asked[inp.Name] = now
+		incomplete = append(incomplete, inp.Name)
+	}
+
+	var events []*BrowseEvent
+	for name, prev := range b.entries {
+		if _, ok := current[name]; !ok {
+			events = append(events, &BrowseEvent{Type: BrowseRemoved, Entry: prev})
+		}
+	}
+	for name, inp := range current {
+		prev, ok := b.entries[name]
+		switch {
+		case !ok:
+			events = append(events, &BrowseEvent{Type: BrowseAdded, Entry: inp})
+		case !sameEntry(prev, inp):
+			events = append(events, &BrowseEvent{Type: BrowseUpdated, Entry: inp})
+		}
+		delete(b.asked, name)
+	}
+	b.entries = current
+	return events, incomplete
+}
+
+// interested checks if a record belongs to the service being browsed, so
+// that it is refreshed before it expires
+func (b *Browser) interested(rr dns.RR) bool {
+	name := rr.Header().Name
+	if strings.EqualFold(name, b.serviceAddr) {
+		return true
+	}
+	for _, e := range b.entries {
+		if strings.EqualFold(name, e.Name) || strings.EqualFold(name, e.Host) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameEntry checks if two entries describe the same SRV, TXT and address data
+func sameEntry(a, b *ServiceEntry) bool {
+	if a.Host != b.Host || a.Port != b.Port || !slices.Equal(a.InfoFields, b.InfoFields) {
+		return false
+	}
+	if !bytes.Equal(a.AddrV4, b.AddrV4) {
+		return false
+	}
+	if (a.AddrV6IPAddr == nil) != (b.AddrV6IPAddr == nil) {
+		return false
+	}
+	if a.AddrV6IPAddr != nil && (!a.AddrV6IPAddr.IP.Equal(b.AddrV6IPAddr.IP) || a.AddrV6IPAddr.Zone != b.AddrV6IPAddr.Zone) {
+		return false
+	}
+	return true
+}
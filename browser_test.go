@@ -0,0 +1,159 @@
+// Copyright IBM Corp. 2014, 2026
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// serviceMsg returns a response carrying every record of s with the given TTL
+func serviceMsg(s *MDNSService, ttl uint32) *msgAddr {
+	q := dns.Question{Name: s.serviceAddr, Qtype: dns.TypePTR}
+	m := new(dns.Msg)
+	m.Answer = append(s.Records(q), s.AdditionalRecords(q)...)
+	for _, rr := range m.Answer {
+		rr.Header().Ttl = ttl
+	}
+	return &msgAddr{msg: m, src: &net.UDPAddr{IP: net.ParseIP("192.168.0.42"), Port: mdnsPort}}
+}
+
+func TestBrowser_Events(t *testing.T) {
+	s := makeService(t)
+	b := NewBrowser(DefaultParams("_http._tcp"), nil)
+	b.serviceAddr = s.serviceAddr
+	b.cache = newRecordCache()
+	now := time.Now()
+
+	b.addRecords(serviceMsg(s, defaultTTL), now)
+	events, _ := b.update(now)
+	if len(events) != 1 || events[0].Type != BrowseAdded {
+		t.Fatalf("expected a single added event, got: %v", events)
+	}
+	if e := events[0].Entry; e.Name != "hostname._http._tcp.local." || e.Port != 80 || e.Info != "Local web server" {
+		t.Fatalf("bad entry: %+v", e)
+	}
+
+	// Receiving the same records again is not a change
+	b.addRecords(serviceMsg(s, defaultTTL), now)
+	if events, _ := b.update(now); len(events) != 0 {
+		t.Fatalf("expected no events, got: %v", events)
+	}
+
+	s.TXT = []string{"Updated web server"}
+	b.addRecords(serviceMsg(s, defaultTTL), now)
+	b.addRecords(&msgAddr{msg: &dns.Msg{Answer: []dns.RR{&dns.TXT{
+		Hdr: dns.RR_Header{Name: s.instanceAddr, Rrtype: dns.TypeTXT, Class: dns.ClassINET},
+		Txt: []string{"Local web server"},
+	}}}}, now)
+	events, _ = b.update(now.Add(2 * goodbyeTTL))
+	if len(events) != 1 || events[0].Type != BrowseUpdated {
+		t.Fatalf("expected a single updated event, got: %v", events)
+	}
+	if e := events[0].Entry; e.Info != "Updated web server" {
+		t.Fatalf("bad entry: %+v", e)
+	}
+
+	// A goodbye removes the instance once the grace period passes
+	b.addRecords(serviceMsg(s, 0), now)
+	if events, _ := b.update(now); len(events) != 0 {
+		t.Fatalf("expected no events, got: %v", events)
+	}
+	events, _ = b.update(now.Add(2 * goodbyeTTL))
+	if len(events) != 1 || events[0].Type != BrowseRemoved {
+		t.Fatalf("expected a single removed event, got: %v", events)
+	}
+}
+
+func TestBrowser_Expiry(t *testing.T) {
+	s := makeService(t)
+	b := NewBrowser(DefaultParams("_http._tcp"), nil)
+	b.serviceAddr = s.serviceAddr
+	b.cache = newRecordCache()
+	now := time.Now()
+
+	b.addRecords(serviceMsg(s, 10), now)
+	if events, _ := b.update(now); len(events) != 1 || events[0].Type != BrowseAdded {
+		t.Fatalf("expected a single added event, got: %v", events)
+	}
+	events, _ := b.update(now.Add(10 * time.Second))
+	if len(events) != 1 || events[0].Type != BrowseRemoved {
+		t.Fatalf("expected a single removed event, got: %v", events)
+	}
+}
+
+func TestBrowser_Incomplete(t *testing.T) {
+	s := makeService(t)
+	b := NewBrowser(DefaultParams("_http._tcp"), nil)
+	b.serviceAddr = s.serviceAddr
+	b.cache = newRecordCache()
+	now := time.Now()
+
+	resp := serviceMsg(s, defaultTTL)
+	resp.msg.Answer = resp.msg.Answer[:1]
+	b.addRecords(resp, now)
+	events, incomplete := b.update(now)
+	if len(events) != 0 {
+		t.Fatalf("expected no events, got: %v", events)
+	}
+	if len(incomplete) != 1 || incomplete[0] != s.instanceAddr {
+		t.Fatalf("expected %s to be queried, got: %v", s.instanceAddr, incomplete)
+	}
+	if _, incomplete := b.update(now); len(incomplete) != 0 {
+		t.Fatalf("expected no repeated query, got: %v", incomplete)
+	}
+}
+
+func TestServer_Browse(t *testing.T) {
+	serv, err := NewServer(&Config{Zone: makeServiceWithServiceName(t, "_browse._tcp")})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer func() {
+		if err := serv.Shutdown(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	events := make(chan *BrowseEvent, 4)
+	params := DefaultParams("_browse._tcp")
+	params.DisableIPv6 = true
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- NewBrowser(params, events).Run(ctx)
+	}()
+
+	select {
+	case e := <-events:
+		if e.Type != BrowseAdded || e.Entry.Name != "hostname._browse._tcp.local." {
+			t.Fatalf("bad event: %v %+v", e.Type, e.Entry)
+		}
+	case <-ctx.Done():
+		t.Fatalf("timed out waiting for event")
+	}
+
+	// The goodbye sent on shutdown removes the instance
+	if err := serv.Shutdown(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	select {
+	case e := <-events:
+		if e.Type != BrowseRemoved || e.Entry.Name != "hostname._browse._tcp.local." {
+			t.Fatalf("bad event: %v %+v", e.Type, e.Entry)
+		}
+	case <-ctx.Done():
+		t.Fatalf("timed out waiting for event")
+	}
+
+	cancel()
+	if err := <-errCh; err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
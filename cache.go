@@ -0,0 +1,292 @@
+// Copyright IBM Corp. 2014, 2026
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"math/rand/v2"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// goodbyeTTL is how long a record that was withdrawn with a TTL of zero is
+// kept before being removed, as per section 10.1 of RFC 6762.
+const goodbyeTTL = time.Second
+
+// refreshPoints are the fractions of a record's TTL at which it is queried
+// again, as per section 5.2 of RFC 6762. A random variation of up to 2% of
+// the TTL is added to each point.
+var refreshPoints = []float64{0.80, 0.85, 0.90, 0.95}
+
+// sharedCache holds the records received by every client in the process.
+// Expired records are evicted as records are added and looked up. Only
+// Browsers send refresh queries, as they are the only clients that stay
+// interested in the records after a lookup, as per section 5.2 of RFC 6762.
+var sharedCache = newRecordCache()
+
+// cacheKey identifies a set of records with the same name, type and class
+type cacheKey struct {
+	name   string
+	rrtype uint16
+	class  uint16
+}
+
+// cacheEntry is a record held in the cache
+type cacheEntry struct {
+	rr       dns.RR
+	src      *net.UDPAddr
+	received time.Time
+	expires  time.Time
+
+	// refreshes is the number of refresh points already passed, and jitter
+	// is the random variation added to each of them
+	refreshes int
+	jitter    float64
+}
+
+// nextRefresh returns when the record should next be queried, or false once
+// every refresh point has passed
+func (e *cacheEntry) nextRefresh() (time.Time, bool) {
+	if e.refreshes >= len(refreshPoints) {
+		return time.Time{}, false
+	}
+	ttl := e.expires.Sub(e.received)
+	return e.received.Add(time.Duration(float64(ttl) * (refreshPoints[e.refreshes] + e.jitter))), true
+}
+
+// recordCache holds received records until their TTL expires. It is safe
+// for concurrent use.
+type recordCache struct {
+	mu      sync.Mutex
+	entries map[cacheKey][]*cacheEntry
+
+	// swept is when the expired records were last evicted
+	swept time.Time
+}
+
+// sweepInterval is the shortest time between two evictions of the expired
+// records while records are added or looked up
+const sweepInterval = time.Second
+
+// newRecordCache returns an empty cache
+func newRecordCache() *recordCache {
+	return &recordCache{
+		entries: make(map[cacheKey][]*cacheEntry),
+	}
+}
+
+// keyFor returns the cache key of a record
+func keyFor(name string, rrtype, class uint16) cacheKey {
+	return cacheKey{name: strings.ToLower(name), rrtype: rrtype, class: class &^ cacheFlush}
+}
+
+// add stores a record received from src at now, replacing any copy of the
+// same record. A record with a TTL of zero is a goodbye, and is kept for
+// one more second as per section 10.1 of RFC 6762. A record with the
+// cache-flush bit set replaces the records with the same name and type that
+// were received more than a second earlier, as per section 10.2 of RFC 6762.
+func (c *recordCache) add(rr dns.RR, src *net.UDPAddr, now time.Time) {
+	// Store the record as a querier would send it back as a known answer
+	flush := isUnique(rr)
+	rr = withoutCacheFlush(rr)
+	hdr := rr.Header()
+	e := &cacheEntry{
+		rr:       rr,
+		src:      src,
+		received: now,
+		expires:  now.Add(time.Duration(hdr.Ttl) * time.Second),
+		jitter:   rand.Float64() * 0.02,
+	}
+	if hdr.Ttl == 0 {
+		e.expires = now.Add(goodbyeTTL)
+		e.refreshes = len(refreshPoints)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sweep(now)
+	key := keyFor(hdr.Name, hdr.Rrtype, hdr.Class)
+	entries := c.entries[key]
+	if flush {
+		// "...any records with the same name, rrtype, and rrclass that were
+		// received more than one second ago are declared invalid, and marked
+		// to expire from the cache in one second."
+		for _, old := range entries {
+			if now.Sub(old.received) > time.Second && old.expires.After(now.Add(time.Second)) {
+				old.expires = now.Add(time.Second)
+				old.refreshes = len(refreshPoints)
+			}
+		}
+	}
+	for i, old := range entries {
+		if dns.IsDuplicate(old.rr, rr) {
+			entries[i] = e
+			return
+		}
+	}
+	c.entries[key] = append(entries, e)
+}
+
+// lookup returns copies of the live records with the given name and type,
+// oldest first. The copies can be read once the cache is unlocked, while
+// add and refresh change the entries held.
+func (c *recordCache) lookup(name string, rrtype uint16, now time.Time) []*cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sweep(now)
+	var live []*cacheEntry
+	for _, e := range c.entries[keyFor(name, rrtype, dns.ClassINET)] {
+		if now.Before(e.expires) {
+			e := *e
+			live = append(live, &e)
+		}
+	}
+	slices.SortFunc(live, func(a, b *cacheEntry) int {
+		return a.received.Compare(b.received)
+	})
+	return live
+}
+
+// knownAnswers returns copies of the live PTR records held for name that
+// have more than half of their TTL remaining, with the TTL set to the time
+// remaining. These are the known answers for a query of name, as per section
+// 7.1 of RFC 6762.
+func (c *recordCache) knownAnswers(name string, now time.Time) []dns.RR {
+	var known []dns.RR
+	for _, e := range c.lookup(name, dns.TypePTR, now) {
+		ttl := time.Duration(e.rr.Header().Ttl) * time.Second
+		remaining := e.expires.Sub(now)
+		if ttl == 0 || 2*remaining <= ttl {
+			continue
+		}
+		rr := dns.Copy(e.rr)
+		rr.Header().Ttl = uint32(remaining / time.Second)
+		known = append(known, rr)
+	}
+	return known
+}
+
+// expire evicts the records whose TTL has run out at now
+func (c *recordCache) expire(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.evict(now)
+}
+
+// sweep evicts the expired records if it hasn't done so within the last
+// sweepInterval, so that records no Browser is interested in don't stay in
+// the cache forever. The cache must be locked.
+func (c *recordCache) sweep(now time.Time) {
+	if now.Sub(c.swept) < sweepInterval {
+		return
+	}
+	c.evict(now)
+}
+
+// evict removes the records whose TTL has run out at now. The cache must be
+// locked.
+func (c *recordCache) evict(now time.Time) {
+	c.swept = now
+	for key, entries := range c.entries {
+		entries = slices.DeleteFunc(entries, func(e *cacheEntry) bool {
+			return !now.Before(e.expires)
+		})
+		if len(entries) == 0 {
+			delete(c.entries, key)
+		} else {
+			c.entries[key] = entries
+		}
+	}
+}
+
+// refresh returns the questions that should be sent to refresh the wanted
+// records that have reached one of their refresh points at now
+func (c *recordCache) refresh(now time.Time, want func(dns.RR) bool) []dns.Question {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var questions []dns.Question
+	for key, entries := range c.entries {
+		due := false
+		for _, e := range entries {
+			at, ok := e.nextRefresh()
+			if !ok || now.Before(at) || !want(e.rr) {
+				continue
+			}
+			for ok && !now.Before(at) {
+				e.refreshes++
+				at, ok = e.nextRefresh()
+			}
+			due = true
+		}
+		if due {
+			questions = append(questions, dns.Question{
+				Name:   entries[0].rr.Header().Name,
+				Qtype:  key.rrtype,
+				Qclass: key.class,
+			})
+		}
+	}
+	return questions
+}
+
+// next returns how long until the next record held expires or a wanted
+// record reaches a refresh point, capped at limit
+func (c *recordCache) next(now time.Time, limit time.Duration, want func(dns.RR) bool) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	next := limit
+	for _, entries := range c.entries {
+		for _, e := range entries {
+			if d := e.expires.Sub(now); d < next {
+				next = d
+			}
+			if at, ok := e.nextRefresh(); ok && at.Sub(now) < next && want(e.rr) {
+				next = at.Sub(now)
+			}
+		}
+	}
+	return max(next, 0)
+}
+
+// entry builds the service entry a PTR record points to from the cached
+// records, using the same record handling as a query
+func (c *recordCache) entry(ptr *cacheEntry, now time.Time) *ServiceEntry {
+	inprogress := make(map[string]*ServiceEntry)
+	handleRecord(inprogress, ptr.rr, ptr.src)
+	return c.instanceEntry(inprogress, ptr.rr.(*dns.PTR).Ptr, now)
+}
+
+// instanceEntry applies the cached records of an instance and of its host to
+// the in-progress entries, and returns the entry of the instance
+func (c *recordCache) instanceEntry(inprogress map[string]*ServiceEntry, name string, now time.Time) *ServiceEntry {
+	inp := ensureName(inprogress, name)
+	for _, rrtype := range []uint16{dns.TypeSRV, dns.TypeTXT, dns.TypeNSEC} {
+		for _, e := range c.lookup(name, rrtype, now) {
+			handleRecord(inprogress, e.rr, e.src)
+		}
+	}
+	if inp.Host == "" {
+		return inp
+	}
+	for _, rrtype := range []uint16{dns.TypeA, dns.TypeAAAA, dns.TypeNSEC} {
+		for _, e := range c.lookup(inp.Host, rrtype, now) {
+			handleRecord(inprogress, e.rr, e.src)
+		}
+	}
+	return inp
+}
+
+// serviceEntries returns the service entries for every live PTR record held for
+// serviceAddr
+func (c *recordCache) serviceEntries(serviceAddr string, now time.Time) []*ServiceEntry {
+	var entries []*ServiceEntry
+	for _, ptr := range c.lookup(serviceAddr, dns.TypePTR, now) {
+		entries = append(entries, c.entry(ptr, now))
+	}
+	return entries
+}
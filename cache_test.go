@@ -0,0 +1,212 @@
+// Copyright IBM Corp. 2014, 2026
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func makeA(name string, ip net.IP, ttl uint32) *dns.A {
+	return &dns.A{
+		Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+		A:   ip,
+	}
+}
+
+// makeUniqueA returns an A record with the cache-flush bit set
+func makeUniqueA(name string, ip net.IP, ttl uint32) *dns.A {
+	a := makeA(name, ip, ttl)
+	a.Hdr.Class |= cacheFlush
+	return a
+}
+
+func TestRecordCache_AddLookup(t *testing.T) {
+	c := newRecordCache()
+	now := time.Now()
+
+	c.add(makeA("testhost.local.", net.IPv4(192, 168, 0, 1), 10), nil, now)
+	c.add(makeA("TestHost.local.", net.IPv4(192, 168, 0, 2), 10), nil, now)
+	c.add(makeA("testhost.local.", net.IPv4(192, 168, 0, 1), 20), nil, now)
+
+	recs := c.lookup("testhost.local.", dns.TypeA, now)
+	if len(recs) != 2 {
+		t.Fatalf("bad: %v", recs)
+	}
+	if recs := c.lookup("testhost.local.", dns.TypeAAAA, now); len(recs) != 0 {
+		t.Fatalf("bad: %v", recs)
+	}
+
+	// The second copy of 192.168.0.1 replaced the first, extending its TTL
+	recs = c.lookup("testhost.local.", dns.TypeA, now.Add(15*time.Second))
+	if len(recs) != 1 || !recs[0].rr.(*dns.A).A.Equal(net.IPv4(192, 168, 0, 1)) {
+		t.Fatalf("bad: %v", recs)
+	}
+
+	c.expire(now.Add(20 * time.Second))
+	if len(c.entries) != 0 {
+		t.Fatalf("expected cache to be empty: %v", c.entries)
+	}
+}
+
+func TestRecordCache_Sweep(t *testing.T) {
+	c := newRecordCache()
+	now := time.Now()
+
+	// Expired records are evicted as others are added, without a Browser
+	// calling expire
+	c.add(makeA("old.local.", net.IPv4(192, 168, 0, 1), 1), nil, now)
+	c.add(makeA("new.local.", net.IPv4(192, 168, 0, 2), 10), nil, now.Add(2*time.Second))
+	if _, ok := c.entries[keyFor("old.local.", dns.TypeA, dns.ClassINET)]; ok || len(c.entries) != 1 {
+		t.Fatalf("expected the expired record to be evicted: %v", c.entries)
+	}
+}
+
+func TestRecordCache_Goodbye(t *testing.T) {
+	c := newRecordCache()
+	now := time.Now()
+
+	c.add(makeA("testhost.local.", net.IPv4(192, 168, 0, 1), defaultTTL), nil, now)
+	c.add(makeA("testhost.local.", net.IPv4(192, 168, 0, 1), 0), nil, now)
+	if recs := c.lookup("testhost.local.", dns.TypeA, now); len(recs) != 1 {
+		t.Fatalf("bad: %v", recs)
+	}
+	if recs := c.lookup("testhost.local.", dns.TypeA, now.Add(goodbyeTTL)); len(recs) != 0 {
+		t.Fatalf("bad: %v", recs)
+	}
+}
+
+func TestRecordCache_CacheFlush(t *testing.T) {
+	c := newRecordCache()
+	now := time.Now()
+
+	c.add(makeA("testhost.local.", net.IPv4(192, 168, 0, 1), defaultTTL), nil, now.Add(-2*time.Second))
+	c.add(makeUniqueA("testhost.local.", net.IPv4(192, 168, 0, 2), defaultTTL), nil, now)
+	c.add(makeUniqueA("testhost.local.", net.IPv4(192, 168, 0, 3), defaultTTL), nil, now)
+	if recs := c.lookup("testhost.local.", dns.TypeA, now); len(recs) != 3 {
+		t.Fatalf("bad: %v", recs)
+	}
+
+	// Only the record received more than a second earlier is flushed
+	recs := c.lookup("testhost.local.", dns.TypeA, now.Add(time.Second))
+	if len(recs) != 2 {
+		t.Fatalf("bad: %v", recs)
+	}
+	for _, e := range recs {
+		if e.rr.Header().Class != dns.ClassINET {
+			t.Fatalf("expected the cache-flush bit to be cleared: %v", e.rr)
+		}
+	}
+}
+
+func TestRecordCache_ConcurrentFlush(t *testing.T) {
+	c := newRecordCache()
+	name := "1.0.168.192.in-addr.arpa."
+	ptr := makePTR(name, "testhost.local.", defaultTTL)
+	ptr.Hdr.Class |= cacheFlush
+	c.add(ptr, nil, time.Now().Add(-2*time.Second))
+
+	// Unique records flush the entries that known answers are built from
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			c.add(ptr, nil, time.Now())
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		c.knownAnswers(name, time.Now())
+	}
+	<-done
+}
+
+func TestRecordCache_Refresh(t *testing.T) {
+	c := newRecordCache()
+	now := time.Now()
+	all := func(dns.RR) bool { return true }
+	none := func(dns.RR) bool { return false }
+
+	c.add(makeA("testhost.local.", net.IPv4(192, 168, 0, 1), 100), nil, now)
+	if qs := c.refresh(now.Add(79*time.Second), all); len(qs) != 0 {
+		t.Fatalf("refreshed too early: %v", qs)
+	}
+	if got := c.next(now, time.Hour, all); got < 80*time.Second || got > 82*time.Second {
+		t.Fatalf("bad next refresh: %v", got)
+	}
+	if got := c.next(now, time.Hour, none); got != 100*time.Second {
+		t.Fatalf("bad next expiry: %v", got)
+	}
+	if qs := c.refresh(now.Add(83*time.Second), none); len(qs) != 0 {
+		t.Fatalf("refreshed unwanted record: %v", qs)
+	}
+
+	for _, at := range []time.Duration{83, 88, 93, 98} {
+		qs := c.refresh(now.Add(at*time.Second), all)
+		if len(qs) != 1 || qs[0].Name != "testhost.local." || qs[0].Qtype != dns.TypeA {
+			t.Fatalf("bad refresh at %ds: %v", at, qs)
+		}
+		if qs := c.refresh(now.Add(at*time.Second), all); len(qs) != 0 {
+			t.Fatalf("refreshed twice at %ds: %v", at, qs)
+		}
+	}
+	if qs := c.refresh(now.Add(99*time.Second), all); len(qs) != 0 {
+		t.Fatalf("refreshed after the last refresh point: %v", qs)
+	}
+}
+
+func TestRecordCache_ServiceEntries(t *testing.T) {
+	s := makeService(t)
+	c := newRecordCache()
+	now := time.Now()
+
+	resp := serviceMsg(s, defaultTTL)
+	for _, rr := range resp.msg.Answer {
+		c.add(rr, resp.src, now)
+	}
+	entries := c.serviceEntries("_http._tcp.local.", now)
+	if len(entries) != 1 {
+		t.Fatalf("bad: %v", entries)
+	}
+	e := entries[0]
+	if !e.complete() || e.Name != s.instanceAddr || e.Host != s.HostName || e.Port != s.Port {
+		t.Fatalf("bad entry: %+v", e)
+	}
+}
+
+func TestClient_QueryFromCache(t *testing.T) {
+	s := makeServiceWithServiceName(t, "_cached._tcp")
+	c, err := newClient(true, false, log.Default())
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Close()
+	c.cache = newRecordCache()
+	resp := serviceMsg(s, defaultTTL)
+	for _, rr := range resp.msg.Answer {
+		c.cache.add(rr, resp.src, time.Now())
+	}
+
+	entries := make(chan *ServiceEntry, 1)
+	err = c.query(&QueryParam{
+		Service: "_cached._tcp",
+		Domain:  "local",
+		Timeout: 10 * time.Millisecond,
+		Entries: entries,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	select {
+	case e := <-entries:
+		if e.Name != s.instanceAddr {
+			t.Fatalf("bad entry: %+v", e)
+		}
+	default:
+		t.Fatalf("expected an entry from the cache")
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"slices"
 	"strings"
 	"sync/atomic"
 	"time"
@@ -32,6 +33,10 @@ type ServiceEntry struct {
 
 	hasTXT bool
 	sent   bool
+
+	// noAddrs is set when an NSEC record shows that the host has no
+	// addresses, so the entry can't be completed
+	noAddrs bool
 }
 
 // complete is used to check if we have all the info we need
@@ -39,9 +44,17 @@ func (s *ServiceEntry) complete() bool {
 	return (s.AddrV4 != nil || s.AddrV6 != nil || s.Addr != nil) && s.Port != 0 && s.hasTXT
 }
 
+// snapshot returns a copy of the entry that is safe to hand to the caller
+// while later records keep updating the original
+func (s *ServiceEntry) snapshot() *ServiceEntry {
+	c := *s
+	return &c
+}
+
 // QueryParam is used to customize how a Lookup is performed
 type QueryParam struct {
 	Service             string               // Service to lookup
+	Subtype             string               // Only lookup instances of this subtype (e.g. "_printer"), as per 7.1 in RFC 6763
 	Domain              string               // Lookup domain, default "local"
 	Timeout             time.Duration        // Lookup timeout, default 1 second
 	Interface           *net.Interface       // Multicast interface to use
@@ -139,6 +152,9 @@ type client struct {
 	closed   atomic.Int32
 	closedCh chan struct{} // TODO(reddaly): This doesn't appear to be used.
 
+	// cache holds the records received, and is shared by every client
+	cache *recordCache
+
 	log *log.Logger
 }
 
@@ -191,6 +207,32 @@ func newClient(v4 bool, v6 bool, logger *log.Logger) (*client, error) {
 		return nil, fmt.Errorf("failed to bind to any multicast udp port")
 	}
 
+	// Go disables multicast loopback on these sockets, but responders on
+	// this host need to see the queries sent from them
+	if mconn4 != nil {
+		if err := ipv4.NewPacketConn(mconn4).SetMulticastLoopback(true); err != nil {
+			logger.Printf("[ERR] mdns: Failed to enable udp4 multicast loopback: %v", err)
+		}
+	}
+	if mconn6 != nil {
+		if err := ipv6.NewPacketConn(mconn6).SetMulticastLoopback(true); err != nil {
+			logger.Printf("[ERR] mdns: Failed to enable udp6 multicast loopback: %v", err)
+		}
+	}
+
+	// Ask for the interface each packet arrives on. This isn't supported on
+	// every platform, in which case the interface is left unknown
+	for _, conn := range []*net.UDPConn{uconn4, mconn4} {
+		if conn != nil {
+			_ = ipv4.NewPacketConn(conn).SetControlMessage(ipv4.FlagInterface, true)
+		}
+	}
+	for _, conn := range []*net.UDPConn{uconn6, mconn6} {
+		if conn != nil {
+			_ = ipv6.NewPacketConn(conn).SetControlMessage(ipv6.FlagInterface, true)
+		}
+	}
+
 	// Check that unicast and multicast connections have been made for IPv4 and IPv6
 	// and disable the respective protocol if not.
 	if uconn4 == nil || mconn4 == nil {
@@ -217,6 +259,7 @@ func newClient(v4 bool, v6 bool, logger *log.Logger) (*client, error) {
 		ipv4UnicastConn:   uconn4,
 		ipv6UnicastConn:   uconn6,
 		closedCh:          make(chan struct{}),
+		cache:             sharedCache,
 		log:               logger,
 	}
 	return c, nil
@@ -277,23 +320,33 @@ func (c *client) setInterface(iface *net.Interface) error {
 type msgAddr struct {
 	msg *dns.Msg
 	src *net.UDPAddr
+
+	// ifIndex is the index of the interface the message arrived on, or 0 if
+	// it isn't known
+	ifIndex int
+}
+
+// serviceName returns the name to query for the instances of a service, or
+// of one of its subtypes
+func serviceName(service, subtype, domain string) string {
+	name := fmt.Sprintf("%s.%s.", trimDot(service), trimDot(domain))
+	if subtype != "" {
+		name = fmt.Sprintf("%s._sub.%s", trimDot(subtype), name)
+	}
+	return name
 }
 
 // query is used to perform a lookup and stream results
 func (c *client) query(params *QueryParam) error {
 	// Create the service name
-	serviceAddr := fmt.Sprintf("%s.%s.", trimDot(params.Service), trimDot(params.Domain))
+	serviceAddr := serviceName(params.Service, params.Subtype, params.Domain)
+
+	// Instances of subtypes are named under the service itself
+	instanceDomain := serviceName(params.Service, "", params.Domain)
 
 	// Start listening for response packets
 	msgCh := make(chan *msgAddr, 32)
-	if c.use_ipv4 {
-		go c.recv(c.ipv4UnicastConn, msgCh)
-		go c.recv(c.ipv4MulticastConn, msgCh)
-	}
-	if c.use_ipv6 {
-		go c.recv(c.ipv6UnicastConn, msgCh)
-		go c.recv(c.ipv6MulticastConn, msgCh)
-	}
+	c.listen(msgCh)
 
 	// Send the query
 	m := new(dns.Msg)
@@ -312,83 +365,66 @@ func (c *client) query(params *QueryParam) error {
 		return err
 	}
 
-	// Map the in-progress responses
+	// Map the in-progress responses, starting with the complete entries
+	// already held in the cache
 	inprogress := make(map[string]*ServiceEntry)
+	for _, inp := range c.cache.serviceEntries(serviceAddr, time.Now()) {
+		if !inp.complete() {
+			continue
+		}
+		inprogress[inp.Name] = inp
+		if inp.Host != inp.Name {
+			inprogress[inp.Host] = inp
+		}
+		inp.sent = true
+		select {
+		case params.Entries <- inp.snapshot():
+		default:
+		}
+	}
 
 	// Listen until we reach the timeout
 	finish := time.After(params.Timeout)
 	for {
 		select {
 		case resp := <-msgCh:
-			var inp *ServiceEntry
+			// A response may carry the records of several entries
+			var updated []*ServiceEntry
+			now := time.Now()
 			for _, answer := range append(resp.msg.Answer, resp.msg.Extra...) {
-				// TODO(reddaly): Check that response corresponds to serviceAddr?
-				switch rr := answer.(type) {
-				case *dns.PTR:
-					// Create new entry for this
-					inp = ensureName(inprogress, rr.Ptr)
-
-				case *dns.SRV:
-					// Check for a target mismatch
-					if rr.Target != rr.Hdr.Name {
-						alias(inprogress, rr.Hdr.Name, rr.Target)
-					}
+				c.cache.add(answer, resp.src, now)
 
-					// Get the port
-					inp = ensureName(inprogress, rr.Hdr.Name)
-					inp.Host = rr.Target
-					inp.Port = int(rr.Port)
-
-				case *dns.TXT:
-					// Pull out the txt
-					inp = ensureName(inprogress, rr.Hdr.Name)
-					inp.Info = strings.Join(rr.Txt, "|")
-					inp.InfoFields = rr.Txt
-					inp.hasTXT = true
-
-				case *dns.A:
-					// Pull out the IP
-					inp = ensureName(inprogress, rr.Hdr.Name)
-					inp.Addr = rr.A // @Deprecated
-					inp.AddrV4 = rr.A
-
-				case *dns.AAAA:
-					// Pull out the IP
-					inp = ensureName(inprogress, rr.Hdr.Name)
-					inp.Addr = rr.AAAA   // @Deprecated
-					inp.AddrV6 = rr.AAAA // @Deprecated
-					inp.AddrV6IPAddr = &net.IPAddr{IP: rr.AAAA}
-					// link-local IPv6 addresses must be qualified with a zone (interface). Zone is
-					// specific to this machine/network-namespace and so won't be carried in the
-					// mDNS message itself. We borrow the zone from the source address of the UDP
-					// packet, as the link-local address should be valid on that interface.
-					if rr.AAAA.IsLinkLocalUnicast() || rr.AAAA.IsLinkLocalMulticast() {
-						inp.AddrV6IPAddr.Zone = resp.src.Zone
-					}
+				// TODO(reddaly): Check that response corresponds to serviceAddr?
+				if e := handleRecord(inprogress, answer, resp.src); e != nil && !slices.Contains(updated, e) {
+					updated = append(updated, e)
 				}
 			}
 
-			if inp == nil {
-				continue
-			}
-
-			// Check if this entry is complete
-			if inp.complete() {
-				if inp.sent {
-					continue
-				}
-				inp.sent = true
-				select {
-				case params.Entries <- inp:
-				default:
-				}
-			} else {
-				// Fire off a node specific query
-				m := new(dns.Msg)
-				m.SetQuestion(inp.Name, dns.TypePTR)
-				m.RecursionDesired = false
-				if err := c.sendQuery(m); err != nil {
-					c.log.Printf("[ERR] mdns: Failed to query instance %s: %v", inp.Name, err)
+			for _, inp := range updated {
+				// Check if this entry is complete
+				if inp.complete() {
+					if inp.sent {
+						continue
+					}
+					inp.sent = true
+					select {
+					case params.Entries <- inp.snapshot():
+					default:
+					}
+				} else if !inp.noAddrs && strings.HasSuffix(inp.Name, "."+instanceDomain) {
+					// Fire off a node specific query for the missing records
+					m := new(dns.Msg)
+					m.SetQuestion(inp.Name, dns.TypeSRV)
+					m.Question = append(m.Question, dns.Question{Name: inp.Name, Qtype: dns.TypeTXT, Qclass: dns.ClassINET})
+					if inp.Host != "" {
+						m.Question = append(m.Question,
+							dns.Question{Name: inp.Host, Qtype: dns.TypeA, Qclass: dns.ClassINET},
+							dns.Question{Name: inp.Host, Qtype: dns.TypeAAAA, Qclass: dns.ClassINET})
+					}
+					m.RecursionDesired = false
+					if err := c.sendQuery(m); err != nil {
+						c.log.Printf("[ERR] mdns: Failed to query instance %s: %v", inp.Name, err)
+					}
 				}
 			}
 		case <-finish:
@@ -397,35 +433,169 @@ func (c *client) query(params *QueryParam) error {
 	}
 }
 
-// sendQuery is used to multicast a query out
-func (c *client) sendQuery(q *dns.Msg) error {
-	buf, err := q.Pack()
-	if err != nil {
-		return err
+// listen starts receiving on every open connection, delivering the
+// messages to msgCh until the client is closed
+func (c *client) listen(msgCh chan *msgAddr) {
+	if c.use_ipv4 {
+		go c.recv(c.ipv4UnicastConn, msgCh)
+		go c.recv(c.ipv4MulticastConn, msgCh)
 	}
-	if c.ipv4UnicastConn != nil {
-		_, err = c.ipv4UnicastConn.WriteToUDP(buf, ipv4Addr)
-		if err != nil {
-			return err
+	if c.use_ipv6 {
+		go c.recv(c.ipv6UnicastConn, msgCh)
+		go c.recv(c.ipv6MulticastConn, msgCh)
+	}
+}
+
+// handleRecord applies a single resource record to the in-progress entries,
+// returning the entry it updated or nil if the record was not of interest
+func handleRecord(inprogress map[string]*ServiceEntry, answer dns.RR, src *net.UDPAddr) *ServiceEntry {
+	var inp *ServiceEntry
+	switch rr := answer.(type) {
+	case *dns.PTR:
+		// Create new entry for this
+		inp = ensureName(inprogress, rr.Ptr)
+
+	case *dns.SRV:
+		// Check for a target mismatch
+		if rr.Target != rr.Hdr.Name {
+			alias(inprogress, rr.Hdr.Name, rr.Target)
+		}
+
+		// Get the port
+		inp = ensureName(inprogress, rr.Hdr.Name)
+		inp.Host = rr.Target
+		inp.Port = int(rr.Port)
+
+	case *dns.TXT:
+		// Pull out the txt
+		inp = ensureName(inprogress, rr.Hdr.Name)
+		inp.Info = strings.Join(rr.Txt, "|")
+		inp.InfoFields = rr.Txt
+		inp.hasTXT = true
+
+	case *dns.A:
+		// Pull out the IP
+		inp = ensureName(inprogress, rr.Hdr.Name)
+		inp.Addr = rr.A // @Deprecated
+		inp.AddrV4 = rr.A
+
+	case *dns.AAAA:
+		// Pull out the IP
+		inp = ensureName(inprogress, rr.Hdr.Name)
+		inp.Addr = rr.AAAA   // @Deprecated
+		inp.AddrV6 = rr.AAAA // @Deprecated
+		inp.AddrV6IPAddr = ipAddr(rr.AAAA, src)
+
+	case *dns.NSEC:
+		// RFC 6762, section 6.1.  Negative Responses
+		//
+		// The types missing from the bitmap don't exist for the name, so
+		// there is no point waiting for them
+		inp = ensureName(inprogress, rr.Hdr.Name)
+		if strings.EqualFold(inp.Name, rr.Hdr.Name) && !slices.Contains(rr.TypeBitMap, dns.TypeTXT) {
+			inp.hasTXT = true
+		}
+		if strings.EqualFold(inp.Host, rr.Hdr.Name) && !slices.Contains(rr.TypeBitMap, dns.TypeA) &&
+			!slices.Contains(rr.TypeBitMap, dns.TypeAAAA) {
+			inp.noAddrs = true
 		}
 	}
-	if c.ipv6UnicastConn != nil {
-		_, err = c.ipv6UnicastConn.WriteToUDP(buf, ipv6Addr)
+	return inp
+}
+
+// ipAddr returns an address received from src
+func ipAddr(ip net.IP, src *net.UDPAddr) *net.IPAddr {
+	addr := &net.IPAddr{IP: ip}
+	// link-local IPv6 addresses must be qualified with a zone (interface). Zone is
+	// specific to this machine/network-namespace and so won't be carried in the
+	// mDNS message itself. We borrow the zone from the source address of the UDP
+	// packet, as the link-local address should be valid on that interface.
+	if ip.To4() == nil && (ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast()) {
+		addr.Zone = src.Zone
+	}
+	return addr
+}
+
+// sendQuery is used to multicast a query out, along with the answers we
+// already know
+func (c *client) sendQuery(q *dns.Msg) error {
+	return c.sendPackets(c.knownAnswerPackets(q, time.Now()))
+}
+
+// sendPackets multicasts query packets as they are. They are sent from port
+// 5353 so that responders treat them as mDNS queries, honouring their known
+// answers, rather than answering them as a legacy querier, as per section
+// 6.7 of RFC 6762.
+func (c *client) sendPackets(packets []*dns.Msg) error {
+	conn4, conn6 := c.ipv4MulticastConn, c.ipv6MulticastConn
+	for _, m := range packets {
+		buf, err := m.Pack()
 		if err != nil {
 			return err
 		}
+		if conn4 != nil {
+			_, err = conn4.WriteToUDP(buf, ipv4Addr)
+			if err != nil {
+				return err
+			}
+		}
+		if conn6 != nil {
+			_, err = conn6.WriteToUDP(buf, ipv6Addr)
+			if err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
 
+// knownAnswerPackets adds the PTR records held in the cache for the
+// questions in q to its answer section, so that responders do not repeat
+// them (RFC 6762, section 7.1). If the known answers do not fit in a single
+// packet they are spread over several, with the TC bit set on all but the
+// last (RFC 6762, section 7.2).
+func (c *client) knownAnswerPackets(q *dns.Msg, now time.Time) []*dns.Msg {
+	var known []dns.RR
+	for _, question := range q.Question {
+		if question.Qtype == dns.TypePTR || question.Qtype == dns.TypeANY {
+			known = append(known, c.cache.knownAnswers(question.Name, now)...)
+		}
+	}
+	if len(known) == 0 {
+		return []*dns.Msg{q}
+	}
+
+	m := q.Copy()
+	m.Compress = true
+	packets := []*dns.Msg{m}
+	for _, rr := range known {
+		m.Answer = append(m.Answer, rr)
+		if m.Len() <= maxPacketSize || len(m.Answer) == 1 {
+			continue
+		}
+
+		// Move the answer that doesn't fit to a new packet without questions
+		m.Answer = m.Answer[:len(m.Answer)-1]
+		m.Truncated = true
+		m = &dns.Msg{
+			MsgHdr:   dns.MsgHdr{Id: q.Id},
+			Compress: true,
+			Answer:   []dns.RR{rr},
+		}
+		packets = append(packets, m)
+	}
+	return packets
+}
+
 // recv is used to receive until we get a shutdown
 func (c *client) recv(l *net.UDPConn, msgCh chan *msgAddr) {
 	if l == nil {
 		return
 	}
 	buf := make([]byte, 65536)
+	oob := make([]byte, 128)
 	for c.closed.Load() == 0 {
-		n, addr, err := l.ReadFromUDP(buf)
+		n, oobn, _, addr, err := l.ReadMsgUDP(buf, oob)
 
 		if c.closed.Load() == 1 {
 			return
@@ -440,10 +610,15 @@ func (c *client) recv(l *net.UDPConn, msgCh chan *msgAddr) {
 			c.log.Printf("[ERR] mdns: Failed to unpack packet: %v", err)
 			continue
 		}
+		if !msg.Response {
+			// Queries from other hosts, or our own looped back
+			continue
+		}
 		select {
 		case msgCh <- &msgAddr{
-			msg: msg,
-			src: addr,
+			msg:     msg,
+			src:     addr,
+			ifIndex: ifIndex(oob[:oobn], addr),
 		}:
 		case <-c.closedCh:
 			return
@@ -451,6 +626,23 @@ func (c *client) recv(l *net.UDPConn, msgCh chan *msgAddr) {
 	}
 }
 
+// ifIndex returns the index of the interface a packet from src arrived on,
+// as given by its control messages, or 0 if it isn't known
+func ifIndex(oob []byte, src *net.UDPAddr) int {
+	if src.IP.To4() != nil {
+		var cm ipv4.ControlMessage
+		if err := cm.Parse(oob); err == nil {
+			return cm.IfIndex
+		}
+	} else {
+		var cm ipv6.ControlMessage
+		if err := cm.Parse(oob); err == nil {
+			return cm.IfIndex
+		}
+	}
+	return 0
+}
+
 // ensureName is used to ensure the named node is in progress
 func ensureName(inprogress map[string]*ServiceEntry, name string) *ServiceEntry {
 	if inp, ok := inprogress[name]; ok {
@@ -0,0 +1,130 @@
+// Copyright IBM Corp. 2014, 2026
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func makePTR(name, ptr string, ttl uint32) *dns.PTR {
+	return &dns.PTR{
+		Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: ttl},
+		Ptr: ptr,
+	}
+}
+
+func TestClient_KnownAnswers(t *testing.T) {
+	c := &client{cache: newRecordCache()}
+	now := time.Now()
+	c.cache.add(makePTR("_http._tcp.local.", "fresh._http._tcp.local.", defaultTTL), nil, now)
+	c.cache.add(makePTR("_http._tcp.local.", "stale._http._tcp.local.", defaultTTL), nil, now.Add(-time.Minute))
+	c.cache.add(makePTR("_http._tcp.local.", "gone._http._tcp.local.", 0), nil, now)
+	c.cache.add(makePTR("_other._tcp.local.", "other._other._tcp.local.", defaultTTL), nil, now)
+
+	q := new(dns.Msg)
+	q.SetQuestion("_http._tcp.local.", dns.TypePTR)
+	packets := c.knownAnswerPackets(q, now.Add(time.Second))
+	if len(packets) != 1 {
+		t.Fatalf("expected a single packet, got: %v", packets)
+	}
+	m := packets[0]
+	if m.Truncated || len(m.Question) != 1 || len(m.Answer) != 1 {
+		t.Fatalf("bad packet: %v", m)
+	}
+	ptr := m.Answer[0].(*dns.PTR)
+	if ptr.Ptr != "fresh._http._tcp.local." || ptr.Hdr.Ttl != defaultTTL-1 {
+		t.Fatalf("bad known answer: %v", ptr)
+	}
+	if len(q.Answer) != 0 {
+		t.Fatalf("query should not be modified: %v", q)
+	}
+}
+
+func TestClient_KnownAnswers_Split(t *testing.T) {
+	c := &client{cache: newRecordCache()}
+	now := time.Now()
+	for i := 0; i < 200; i++ {
+		instance := fmt.Sprintf("instance-%d-with-a-fairly-long-name._http._tcp.local.", i)
+		c.cache.add(makePTR("_http._tcp.local.", instance, defaultTTL), nil, now)
+	}
+
+	q := new(dns.Msg)
+	q.SetQuestion("_http._tcp.local.", dns.TypePTR)
+	packets := c.knownAnswerPackets(q, now)
+	if len(packets) < 2 {
+		t.Fatalf("expected the known answers to be split, got %d packets", len(packets))
+	}
+	answers := 0
+	for i, m := range packets {
+		if m.Len() > maxPacketSize {
+			t.Fatalf("packet %d is too large: %d bytes", i, m.Len())
+		}
+		if last := i == len(packets)-1; m.Truncated == last {
+			t.Fatalf("packet %d has the wrong TC bit: %v", i, m.Truncated)
+		}
+		want := 0
+		if i == 0 {
+			want = 1
+		}
+		if len(m.Question) != want {
+			t.Fatalf("packet %d has %d questions, want %d", i, len(m.Question), want)
+		}
+		if _, err := m.Pack(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		answers += len(m.Answer)
+	}
+	if answers != 200 {
+		t.Fatalf("got %d known answers, want 200", answers)
+	}
+}
+
+func TestHandleRecord_NSEC(t *testing.T) {
+	inprogress := make(map[string]*ServiceEntry)
+	src := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 42), Port: mdnsPort}
+	handleRecord(inprogress, &dns.SRV{
+		Hdr:    dns.RR_Header{Name: "instance._http._tcp.local.", Rrtype: dns.TypeSRV, Class: dns.ClassINET},
+		Port:   80,
+		Target: "testhost.local.",
+	}, src)
+	handleRecord(inprogress, makeA("testhost.local.", net.IPv4(192, 168, 0, 42), defaultTTL), src)
+
+	// The host's NSEC record doesn't say anything about the instance's TXT
+	inp := handleRecord(inprogress, nsec("testhost.local.", dns.TypeA), src)
+	if inp.complete() {
+		t.Fatalf("expected the entry to wait for its TXT record: %+v", inp)
+	}
+
+	// The instance's NSEC record shows there is no TXT record to wait for
+	inp = handleRecord(inprogress, nsec("instance._http._tcp.local.", dns.TypeSRV), src)
+	if !inp.complete() || inp.noAddrs {
+		t.Fatalf("expected the entry to be complete: %+v", inp)
+	}
+
+	inprogress = make(map[string]*ServiceEntry)
+	handleRecord(inprogress, &dns.SRV{
+		Hdr:    dns.RR_Header{Name: "instance._http._tcp.local.", Rrtype: dns.TypeSRV, Class: dns.ClassINET},
+		Port:   80,
+		Target: "testhost.local.",
+	}, src)
+	inp = handleRecord(inprogress, nsec("testhost.local."), src)
+	if inp.complete() || !inp.noAddrs {
+		t.Fatalf("expected the entry to have no addresses: %+v", inp)
+	}
+}
+
+func TestIPAddr_Zone(t *testing.T) {
+	src := &net.UDPAddr{IP: net.ParseIP("fe80::2"), Port: mdnsPort, Zone: "eth0"}
+	if addr := ipAddr(net.ParseIP("fe80::1"), src); addr.Zone != "eth0" {
+		t.Fatalf("expected the zone of the source address: %v", addr)
+	}
+	if addr := ipAddr(net.ParseIP("2001:db8::1"), src); addr.Zone != "" {
+		t.Fatalf("expected no zone on a global address: %v", addr)
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright IBM Corp. 2014, 2026
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// HostZone is used to publish the addresses of a host name, without any
+// service, by implementing a Zone. Besides the A and AAAA records of the
+// host name, it publishes the PTR records mapping each address back to the
+// host name under in-addr.arpa or ip6.arpa.
+type HostZone struct {
+	HostName string   // Host machine DNS name (e.g. "mymachine.local.")
+	IPs      []net.IP // IP addresses of the host
+
+	mu sync.RWMutex
+}
+
+// NewHostZone returns a new HostZone publishing the given addresses for
+// hostName.
+//
+// Upon startup, the server checks that hostName is not used by another host.
+// If it is, the host is renamed: see Rename.
+func NewHostZone(hostName string, ips []net.IP) (*HostZone, error) {
+	if err := validateFQDN(hostName); err != nil {
+		return nil, fmt.Errorf("hostName %q is not a fully-qualified domain name: %v", hostName, err)
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("missing host IP addresses")
+	}
+	if err := validateIPs(ips); err != nil {
+		return nil, err
+	}
+	return &HostZone{HostName: hostName, IPs: ips}, nil
+}
+
+// Rename picks a new host name, from "host.local." to "host-2.local.",
+// counting up on further conflicts. The reverse names of the addresses can't
+// be renamed.
+func (h *HostZone) Rename(name string) (string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if name != h.HostName {
+		return "", fmt.Errorf("%s is not the name of this host", name)
+	}
+	h.HostName = nextHostName(h.HostName)
+	return h.HostName, nil
+}
+
+// Records returns DNS records in response to a DNS question.
+func (h *HostZone) Records(q dns.Question) []dns.RR {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if q.Name == h.HostName {
+		var recs []dns.RR
+		switch q.Qtype {
+		case dns.TypeANY:
+			recs = h.hostRecords()
+		case dns.TypeA, dns.TypeAAAA:
+			recs = addressRecords(h.HostName, h.IPs, q.Qtype)
+		}
+		if len(recs) > 0 {
+			return recs
+		}
+		return []dns.RR{h.hostNSEC()}
+	}
+
+	ptr := h.reverseRecord(q.Name)
+	if ptr == nil {
+		return nil
+	}
+	switch q.Qtype {
+	case dns.TypeANY, dns.TypePTR:
+		return []dns.RR{ptr}
+	default:
+		return []dns.RR{nsec(q.Name, dns.TypePTR)}
+	}
+}
+
+// AdditionalRecords returns the records to add to the answers to a DNS
+// question: the addresses of the other family and the NSEC record of the
+// host name for A and AAAA records.
+func (h *HostZone) AdditionalRecords(q dns.Question) []dns.RR {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if q.Name != h.HostName {
+		return nil
+	}
+	switch q.Qtype {
+	case dns.TypeA:
+		return append(addressRecords(h.HostName, h.IPs, dns.TypeAAAA), h.hostNSEC())
+	case dns.TypeAAAA:
+		return append(addressRecords(h.HostName, h.IPs, dns.TypeA), h.hostNSEC())
+	}
+	return nil
+}
+
+// PublishedRecords returns every record the host publishes.
+func (h *HostZone) PublishedRecords() []dns.RR {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	recs := h.hostRecords()
+	for _, ip := range h.IPs {
+		if ptr := h.reverseRecord(reverseAddr(ip)); ptr != nil {
+			recs = append(recs, ptr)
+		}
+	}
+	return recs
+}
+
+// hostRecords returns the A and AAAA records of the host
+func (h *HostZone) hostRecords() []dns.RR {
+	recs := addressRecords(h.HostName, h.IPs, dns.TypeA)
+	return append(recs, addressRecords(h.HostName, h.IPs, dns.TypeAAAA)...)
+}
+
+// hostNSEC returns the NSEC record listing the types of the host name
+func (h *HostZone) hostNSEC() dns.RR {
+	return nsec(h.HostName, recordTypes(h.hostRecords())...)
+}
+
+// reverseRecord returns the PTR record of the reverse name of one of the
+// host's addresses, or nil if name isn't one
+func (h *HostZone) reverseRecord(name string) dns.RR {
+	for _, ip := range h.IPs {
+		if reverseAddr(ip) != name {
+			continue
+		}
+		return &dns.PTR{
+			Hdr: dns.RR_Header{
+				Name:   name,
+				Rrtype: dns.TypePTR,
+				Class:  dns.ClassINET | cacheFlush,
+				Ttl:    defaultTTL,
+			},
+			Ptr: h.HostName,
+		}
+	}
+	return nil
+}
+
+// reverseAddr returns the in-addr.arpa or ip6.arpa name of an address
+func reverseAddr(ip net.IP) string {
+	name, err := dns.ReverseAddr(ip.String())
+	if err != nil {
+		return ""
+	}
+	return name
+}
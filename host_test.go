@@ -0,0 +1,97 @@
+// Copyright IBM Corp. 2014, 2026
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func makeHostZone(t *testing.T) *HostZone {
+	h, err := NewHostZone("testhost.local.", []net.IP{net.IPv4(192, 168, 0, 42), net.ParseIP("fe80::1")})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return h
+}
+
+func TestNewHostZone_BadParams(t *testing.T) {
+	for _, test := range []struct {
+		hostName string
+		ips      []net.IP
+	}{
+		{"testhost.local", []net.IP{net.IPv4(192, 168, 0, 42)}},
+		{"testhost.local.", nil},
+		{"testhost.local.", []net.IP{net.IP("bad")}},
+	} {
+		if _, err := NewHostZone(test.hostName, test.ips); err == nil {
+			t.Fatalf("expected an error for %s %v", test.hostName, test.ips)
+		}
+	}
+}
+
+func TestHostZone_Records(t *testing.T) {
+	h := makeHostZone(t)
+
+	recs := h.Records(dns.Question{Name: "testhost.local.", Qtype: dns.TypeA})
+	if len(recs) != 1 {
+		t.Fatalf("bad: %v", recs)
+	}
+	if a, ok := recs[0].(*dns.A); !ok || !a.A.Equal(net.IPv4(192, 168, 0, 42)) || !isUnique(a) {
+		t.Fatalf("bad A record: %v", recs[0])
+	}
+	if recs := h.Records(dns.Question{Name: "testhost.local.", Qtype: dns.TypeANY}); len(recs) != 2 {
+		t.Fatalf("bad: %v", recs)
+	}
+	recs = h.Records(dns.Question{Name: "testhost.local.", Qtype: dns.TypeTXT})
+	if len(recs) != 1 || recs[0].Header().Rrtype != dns.TypeNSEC {
+		t.Fatalf("expected an NSEC record, got: %v", recs)
+	}
+	extra := h.AdditionalRecords(dns.Question{Name: "testhost.local.", Qtype: dns.TypeA})
+	if len(extra) != 2 || extra[0].Header().Rrtype != dns.TypeAAAA || extra[1].Header().Rrtype != dns.TypeNSEC {
+		t.Fatalf("bad additional records: %v", extra)
+	}
+	if recs := h.Records(dns.Question{Name: "other.local.", Qtype: dns.TypeA}); len(recs) != 0 {
+		t.Fatalf("bad: %v", recs)
+	}
+}
+
+func TestHostZone_Reverse(t *testing.T) {
+	h := makeHostZone(t)
+
+	for _, name := range []string{
+		"42.0.168.192.in-addr.arpa.",
+		"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.e.f.ip6.arpa.",
+	} {
+		recs := h.Records(dns.Question{Name: name, Qtype: dns.TypePTR})
+		if len(recs) != 1 {
+			t.Fatalf("bad: %v", recs)
+		}
+		if ptr, ok := recs[0].(*dns.PTR); !ok || ptr.Hdr.Name != name || ptr.Ptr != "testhost.local." {
+			t.Fatalf("bad PTR record: %v", recs[0])
+		}
+	}
+	if recs := h.Records(dns.Question{Name: "43.0.168.192.in-addr.arpa.", Qtype: dns.TypePTR}); len(recs) != 0 {
+		t.Fatalf("bad: %v", recs)
+	}
+
+	// Every address is published in both directions
+	if recs := h.PublishedRecords(); len(recs) != 4 {
+		t.Fatalf("bad: %v", recs)
+	}
+
+	name, err := h.Rename("testhost.local.")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	recs := h.Records(dns.Question{Name: "42.0.168.192.in-addr.arpa.", Qtype: dns.TypePTR})
+	if len(recs) != 1 || recs[0].(*dns.PTR).Ptr != name {
+		t.Fatalf("expected the PTR record to follow the rename to %s: %v", name, recs)
+	}
+	if _, err := h.Rename("42.0.168.192.in-addr.arpa."); err == nil {
+		t.Fatalf("expected an error renaming a reverse name")
+	}
+}
@@ -0,0 +1,413 @@
+// Copyright IBM Corp. 2014, 2026
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// lookupTimeout is how long a lookup waits for answers when its context has
+// no deadline
+const lookupTimeout = time.Second
+
+// withLookupTimeout returns a context that is done after lookupTimeout if
+// ctx has no deadline, or ctx itself otherwise
+func withLookupTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, lookupTimeout)
+}
+
+// lookupClient returns a client for a single lookup, which is closed once
+// ctx is done
+func lookupClient(ctx context.Context, v4, v6 bool, logger *log.Logger) (*client, error) {
+	client, err := newClient(v4, v6, logger)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			client.Close()
+		case <-client.closedCh:
+		}
+	}()
+	return client, nil
+}
+
+// queryPTR queries the PTR records of name until ctx is done, calling fn
+// with the target of each distinct record, starting with the records held in
+// the cache. It stops early if fn returns false.
+func queryPTR(ctx context.Context, name string, fn func(ptr string) bool) error {
+	client, err := lookupClient(ctx, true, true, log.Default())
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	var seen []string
+	found := func(rr dns.RR) bool {
+		ptr, ok := rr.(*dns.PTR)
+		if !ok || ptr.Hdr.Ttl == 0 || !strings.EqualFold(ptr.Hdr.Name, name) ||
+			slices.ContainsFunc(seen, func(s string) bool { return strings.EqualFold(s, ptr.Ptr) }) {
+			return true
+		}
+		seen = append(seen, ptr.Ptr)
+		return fn(ptr.Ptr)
+	}
+
+	// The records already held in the cache are sent as known answers, so
+	// responders won't repeat them
+	for _, e := range client.cache.lookup(name, dns.TypePTR, time.Now()) {
+		if !found(e.rr) {
+			return nil
+		}
+	}
+
+	msgCh := make(chan *msgAddr, 32)
+	client.listen(msgCh)
+	m := new(dns.Msg)
+	m.SetQuestion(name, dns.TypePTR)
+	m.RecursionDesired = false
+	if err := client.sendQuery(m); err != nil {
+		return err
+	}
+	for {
+		select {
+		case resp := <-msgCh:
+			now := time.Now()
+			for _, rr := range append(resp.msg.Answer, resp.msg.Extra...) {
+				client.cache.add(rr, resp.src, now)
+				if !found(rr) {
+					return nil
+				}
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// LookupAddr looks up the host names of an address over mDNS, the way
+// "avahi-resolve -a" does, by querying the PTR records of its in-addr.arpa
+// or ip6.arpa name. It returns every host name received until ctx is done,
+// or for a second if ctx has no deadline.
+func LookupAddr(ctx context.Context, ip net.IP) ([]string, error) {
+	name, err := dns.ReverseAddr(ip.String())
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := withLookupTimeout(ctx)
+	defer cancel()
+	var names []string
+	err = queryPTR(ctx, name, func(ptr string) bool {
+		names = append(names, ptr)
+		return true
+	})
+	return names, err
+}
+
+// BrowseServiceTypes looks up the types of the services advertised in a
+// domain, by querying "_services._dns-sd._udp.<domain>" as per section 9 of
+// RFC 6763. Each distinct type, such as "_http._tcp.local.", is sent to types
+// once. Sends block until the type is read or ctx is done. It returns once
+// ctx is done, or after a second if ctx has no deadline.
+func BrowseServiceTypes(ctx context.Context, domain string, types chan<- string) error {
+	if domain == "" {
+		domain = "local"
+	}
+	ctx, cancel := withLookupTimeout(ctx)
+	defer cancel()
+	name := fmt.Sprintf("_services._dns-sd._udp.%s.", trimDot(domain))
+	return queryPTR(ctx, name, func(ptr string) bool {
+		select {
+		case types <- ptr:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	})
+}
+
+// ResolveService looks up a service instance whose name is already known,
+// the way "avahi-resolve" does, by querying its SRV and TXT records directly
+// rather than browsing the service, and then the addresses of its host. It
+// returns the entry as soon as it is complete, or an error if it isn't
+// complete by the time ctx is done, or after a second if ctx has no
+// deadline.
+func ResolveService(ctx context.Context, instance, service, domain string) (*ServiceEntry, error) {
+	if domain == "" {
+		domain = "local"
+	}
+	name := fmt.Sprintf("%s.%s.%s.", instance, trimDot(service), trimDot(domain))
+	ctx, cancel := withLookupTimeout(ctx)
+	defer cancel()
+	client, err := lookupClient(ctx, true, true, log.Default())
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	// Start with the records already held in the cache
+	inprogress := make(map[string]*ServiceEntry)
+	inp := client.cache.instanceEntry(inprogress, name, time.Now())
+	if inp.complete() {
+		return inp.snapshot(), nil
+	}
+
+	msgCh := make(chan *msgAddr, 32)
+	client.listen(msgCh)
+	m := new(dns.Msg)
+	m.SetQuestion(name, dns.TypeSRV)
+	m.Question = append(m.Question, dns.Question{Name: name, Qtype: dns.TypeTXT, Qclass: dns.ClassINET})
+	m.RecursionDesired = false
+	if err := client.sendQuery(m); err != nil {
+		return nil, err
+	}
+
+	// Query the addresses of the host once the SRV record names it
+	var host string
+	for {
+		if inp.Host != "" && inp.Host != host && !inp.noAddrs {
+			host = inp.Host
+			m := new(dns.Msg)
+			m.SetQuestion(host, dns.TypeA)
+			m.Question = append(m.Question, dns.Question{Name: host, Qtype: dns.TypeAAAA, Qclass: dns.ClassINET})
+			m.RecursionDesired = false
+			if err := client.sendQuery(m); err != nil {
+				return nil, err
+			}
+		}
+
+		select {
+		case resp := <-msgCh:
+			now := time.Now()
+			for _, rr := range append(resp.msg.Answer, resp.msg.Extra...) {
+				client.cache.add(rr, resp.src, now)
+				handleRecord(inprogress, rr, resp.src)
+			}
+			if inp.complete() {
+				return inp.snapshot(), nil
+			}
+			if inp.noAddrs {
+				return nil, fmt.Errorf("mdns: host %s of %s has no addresses", inp.Host, name)
+			}
+		case <-ctx.Done():
+			return nil, fmt.Errorf("mdns: could not resolve %s: %w", name, ctx.Err())
+		}
+	}
+}
+
+// LookupHost looks up the addresses of a host name over mDNS, such as
+// "mymachine.local.", without relying on the system resolver. Link-local
+// IPv6 addresses carry the zone of the interface they were received on.
+//
+// It returns every address held in the cache or received until ctx is done,
+// or for a second if ctx has no deadline, so that the addresses received
+// from other interfaces are included. It returns an error if there are none.
+func LookupHost(ctx context.Context, name string) ([]net.IPAddr, error) {
+	name = dns.Fqdn(name)
+	ctx, cancel := withLookupTimeout(ctx)
+	defer cancel()
+	client, err := lookupClient(ctx, true, true, log.Default())
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	var addrs []net.IPAddr
+	found := func(rr dns.RR, src *net.UDPAddr) {
+		if rr.Header().Ttl == 0 || !strings.EqualFold(rr.Header().Name, name) {
+			return
+		}
+		var addr *net.IPAddr
+		switch rr := rr.(type) {
+		case *dns.A:
+			addr = ipAddr(rr.A, src)
+		case *dns.AAAA:
+			addr = ipAddr(rr.AAAA, src)
+		default:
+			return
+		}
+		// A link-local address received over IPv4 has no zone, and is only
+		// kept until the same address arrives with one
+		i := slices.IndexFunc(addrs, func(a net.IPAddr) bool {
+			return a.IP.Equal(addr.IP) && (a.Zone == addr.Zone || a.Zone == "" || addr.Zone == "")
+		})
+		switch {
+		case i < 0:
+			addrs = append(addrs, *addr)
+		case addrs[i].Zone == "":
+			addrs[i] = *addr
+		}
+	}
+
+	// Start with the addresses already held in the cache
+	now := time.Now()
+	for _, rrtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		for _, e := range client.cache.lookup(name, rrtype, now) {
+			found(e.rr, e.src)
+		}
+	}
+
+	msgCh := make(chan *msgAddr, 32)
+	client.listen(msgCh)
+	m := new(dns.Msg)
+	m.SetQuestion(name, dns.TypeA)
+	m.Question = append(m.Question, dns.Question{Name: name, Qtype: dns.TypeAAAA, Qclass: dns.ClassINET})
+	m.RecursionDesired = false
+	if err := client.sendQuery(m); err != nil {
+		return nil, err
+	}
+	for {
+		select {
+		case resp := <-msgCh:
+			now := time.Now()
+			for _, rr := range append(resp.msg.Answer, resp.msg.Extra...) {
+				client.cache.add(rr, resp.src, now)
+				found(rr, resp.src)
+			}
+		case <-ctx.Done():
+			if len(addrs) == 0 {
+				return nil, fmt.Errorf("mdns: could not resolve %s: %w", name, ctx.Err())
+			}
+			return addrs, nil
+		}
+	}
+}
+
+// isLocalName checks if a host name is in the ".local" domain, which is
+// resolved over mDNS as per section 3 of RFC 6762
+func isLocalName(host string) bool {
+	return strings.HasSuffix(strings.ToLower(dns.Fqdn(host)), ".local.")
+}
+
+// Dialer connects to addresses like net.Dialer, resolving the host names
+// in the ".local" domain with LookupHost. Other addresses are dialed by the
+// embedded net.Dialer unchanged.
+type Dialer struct {
+	net.Dialer
+}
+
+// Dial connects to the address on the named network.
+func (d *Dialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+// DialContext connects to the address on the named network using the
+// provided context. The ".local" host names are looked up for at most a
+// second within the context, trying each address in turn until one
+// connects.
+func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || !isLocalName(host) {
+		return d.Dialer.DialContext(ctx, network, address)
+	}
+	lookupCtx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+	addrs, err := LookupHost(lookupCtx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	err = fmt.Errorf("mdns: no %s address for %s", network, host)
+	for _, addr := range addrs {
+		v4 := addr.IP.To4() != nil
+		if (v4 && strings.HasSuffix(network, "6")) || (!v4 && strings.HasSuffix(network, "4")) {
+			continue
+		}
+		var conn net.Conn
+		conn, err = d.Dialer.DialContext(ctx, network, net.JoinHostPort(addr.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// Record is a resource record received in response to QueryRecords
+type Record struct {
+	RR        dns.RR
+	Src       *net.UDPAddr   // Address of the responder
+	Interface *net.Interface // Interface the record arrived on, if known
+}
+
+// RecordQueryParam is used to customize how QueryRecords is performed
+type RecordQueryParam struct {
+	Records             chan<- *Record // Records channel
+	Interface           *net.Interface // Multicast interface to use
+	WantUnicastResponse bool           // Unicast response desired, as per 5.4 in RFC
+	DisableIPv4         bool           // Whether to disable usage of IPv4 for MDNS operations. Does not affect received records.
+	DisableIPv6         bool           // Whether to disable usage of IPv6 for MDNS operations. Does not affect received records.
+	Logger              *log.Logger    // Optionally provide a *log.Logger to better manage log output.
+}
+
+// QueryRecords queries the records of any type for a name, such as HINFO or
+// TXT records, and sends every record received that answers the question to
+// params.Records, along with the address it came from and the interface it
+// arrived on. The query is sent without known answers, so that every
+// responder answers. Sends block until the record is read or ctx is done. It
+// returns once ctx is done, or after a second if ctx has no deadline.
+func QueryRecords(ctx context.Context, name string, qtype uint16, params *RecordQueryParam) error {
+	if params.Logger == nil {
+		params.Logger = log.Default()
+	}
+	name = dns.Fqdn(name)
+	ctx, cancel := withLookupTimeout(ctx)
+	defer cancel()
+	client, err := lookupClient(ctx, !params.DisableIPv4, !params.DisableIPv6, params.Logger)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if params.Interface != nil {
+		if err := client.setInterface(params.Interface); err != nil {
+			return err
+		}
+	}
+
+	msgCh := make(chan *msgAddr, 32)
+	client.listen(msgCh)
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	if params.WantUnicastResponse {
+		m.Question[0].Qclass |= 1 << 15
+	}
+	m.RecursionDesired = false
+	if err := client.sendPackets([]*dns.Msg{m}); err != nil {
+		return err
+	}
+	for {
+		select {
+		case resp := <-msgCh:
+			var iface *net.Interface
+			if resp.ifIndex != 0 {
+				iface, _ = net.InterfaceByIndex(resp.ifIndex)
+			}
+			now := time.Now()
+			for _, rr := range append(resp.msg.Answer, resp.msg.Extra...) {
+				client.cache.add(rr, resp.src, now)
+				if !strings.EqualFold(rr.Header().Name, name) || (qtype != dns.TypeANY && rr.Header().Rrtype != qtype) {
+					continue
+				}
+				select {
+				case params.Records <- &Record{RR: rr, Src: resp.src, Interface: iface}:
+				case <-ctx.Done():
+					return nil
+				}
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,286 @@
+// Copyright IBM Corp. 2014, 2026
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"context"
+	"net"
+	"runtime"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestServer_LookupAddr(t *testing.T) {
+	h, err := NewHostZone("lookupaddr.local.", []net.IP{net.IPv4(192, 168, 0, 142)})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	serv, err := NewServer(&Config{Zone: h})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer func() {
+		if err := serv.Shutdown(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	names, err := LookupAddr(ctx, net.IPv4(192, 168, 0, 142))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(names) != 1 || names[0] != "lookupaddr.local." {
+		t.Fatalf("bad names: %v", names)
+	}
+
+	// Nobody publishes other addresses
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	names, err = LookupAddr(ctx, net.IPv4(192, 168, 0, 143))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(names) != 0 {
+		t.Fatalf("bad names: %v", names)
+	}
+}
+
+func TestServer_ResolveService(t *testing.T) {
+	serv, err := NewServer(&Config{Zone: makeServiceWithServiceName(t, "_resolve._tcp")})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer func() {
+		if err := serv.Shutdown(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	entry, err := ResolveService(ctx, "hostname", "_resolve._tcp", "local")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if entry.Name != "hostname._resolve._tcp.local." || entry.Port != 80 || entry.Info != "Local web server" ||
+		!entry.AddrV4.Equal(net.IPv4(192, 168, 0, 42)) {
+		t.Fatalf("bad entry: %+v", entry)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := ResolveService(ctx, "missing", "_resolve._tcp", "local"); err == nil {
+		t.Fatalf("expected an error resolving a missing instance")
+	}
+}
+
+func TestServer_LookupHost(t *testing.T) {
+	h, err := NewHostZone("lookuphost.local.", []net.IP{net.IPv4(192, 168, 0, 141), net.ParseIP("fe80::1")})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	serv, err := NewServer(&Config{Zone: h})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer func() {
+		if err := serv.Shutdown(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	addrs, err := LookupHost(ctx, "lookuphost.local")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(addrs) != 2 || !addrs[0].IP.Equal(net.IPv4(192, 168, 0, 141)) || !addrs[1].IP.Equal(net.ParseIP("fe80::1")) {
+		t.Fatalf("bad addresses: %v", addrs)
+	}
+	if addrs[0].Zone != "" {
+		t.Fatalf("expected no zone on an IPv4 address: %v", addrs[0])
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := LookupHost(ctx, "missing.local."); err == nil {
+		t.Fatalf("expected an error looking up a missing host")
+	}
+}
+
+func TestServer_LookupHostPartialCache(t *testing.T) {
+	h, err := NewHostZone("partialhost.local.", []net.IP{net.IPv4(192, 168, 0, 145)})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	serv, err := NewServer(&Config{Zone: h})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer func() {
+		if err := serv.Shutdown(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}()
+
+	// An address already held in the cache doesn't end the lookup before the
+	// addresses of other responders arrive
+	sharedCache.add(makeA("partialhost.local.", net.IPv4(192, 168, 0, 146), defaultTTL), nil, time.Now())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	addrs, err := LookupHost(ctx, "partialhost.local.")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(addrs) != 2 || !slices.ContainsFunc(addrs, func(a net.IPAddr) bool { return a.IP.Equal(net.IPv4(192, 168, 0, 146)) }) ||
+		!slices.ContainsFunc(addrs, func(a net.IPAddr) bool { return a.IP.Equal(net.IPv4(192, 168, 0, 145)) }) {
+		t.Fatalf("bad addresses: %v", addrs)
+	}
+}
+
+func TestDialer(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer func() {
+		if err := l.Close(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}()
+	go func() {
+		if conn, err := l.Accept(); err == nil {
+			_ = conn.Close()
+		}
+	}()
+
+	h, err := NewHostZone("dialer.local.", []net.IP{net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	serv, err := NewServer(&Config{Zone: h})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer func() {
+		if err := serv.Shutdown(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	var d Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort("dialer.local", port))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Only addresses of the network's family are dialed
+	if _, err := d.DialContext(ctx, "tcp6", net.JoinHostPort("dialer.local", port)); err == nil {
+		t.Fatalf("expected an error dialing an IPv4-only host over tcp6")
+	}
+}
+
+func TestServer_BrowseServiceTypes(t *testing.T) {
+	r, err := NewServiceRegistry(
+		makeServiceWithServiceName(t, "_typea._tcp"),
+		makeServiceWithServiceName(t, "_typeb._udp"),
+		makeRegistryService(t, "other", "_typea._tcp", net.IPv4(192, 168, 0, 42)),
+	)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	serv, err := NewServer(&Config{Zone: r})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer func() {
+		if err := serv.Shutdown(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	types := make(chan string, 8)
+	if err := BrowseServiceTypes(ctx, "local", types); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	close(types)
+	found := make(map[string]int)
+	for typ := range types {
+		found[typ]++
+	}
+	if found["_typea._tcp.local."] != 1 || found["_typeb._udp.local."] != 1 {
+		t.Fatalf("expected each service type once, got: %v", found)
+	}
+}
+
+// hinfoZone answers HINFO questions for a single name
+type hinfoZone struct {
+	name string
+}
+
+func (z hinfoZone) Records(q dns.Question) []dns.RR {
+	if q.Name != z.name || (q.Qtype != dns.TypeHINFO && q.Qtype != dns.TypeANY) {
+		return nil
+	}
+	return []dns.RR{&dns.HINFO{
+		Hdr: dns.RR_Header{Name: z.name, Rrtype: dns.TypeHINFO, Class: dns.ClassINET | cacheFlush, Ttl: defaultTTL},
+		Cpu: "ARM64",
+		Os:  "LINUX",
+	}}
+}
+
+func TestServer_QueryRecords(t *testing.T) {
+	serv, err := NewServer(&Config{Zone: hinfoZone{name: "queryrecords.local."}})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer func() {
+		if err := serv.Shutdown(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	records := make(chan *Record, 4)
+	err = QueryRecords(ctx, "queryrecords.local", dns.TypeHINFO, &RecordQueryParam{
+		Records:     records,
+		DisableIPv6: true,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	close(records)
+	var found []*Record
+	for r := range records {
+		found = append(found, r)
+	}
+	if len(found) != 1 {
+		t.Fatalf("expected a single record, got: %v", found)
+	}
+	if hinfo, ok := found[0].RR.(*dns.HINFO); !ok || hinfo.Cpu != "ARM64" || hinfo.Os != "LINUX" {
+		t.Fatalf("bad record: %v", found[0].RR)
+	}
+	if found[0].Src == nil {
+		t.Fatalf("expected the source address of the record")
+	}
+	if runtime.GOOS == "linux" && found[0].Interface == nil {
+		t.Fatalf("expected the interface the record arrived on")
+	}
+}
@@ -0,0 +1,321 @@
+// Copyright IBM Corp. 2014, 2026
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// probeWait is the longest random delay before the first probe, and
+	// probeInterval the delay between probes, as per section 8.1 of RFC 6762
+	probeWait     = 250 * time.Millisecond
+	probeInterval = 250 * time.Millisecond
+	probeCount    = 3
+
+	// probeDefer is how long to wait before probing again after losing a
+	// simultaneous probe tie-break, as per section 8.2 of RFC 6762
+	probeDefer = time.Second
+
+	// probeConflictLimit is the number of conflicts within probeConflictWindow
+	// after which the server waits probeConflictDelay before each new probe
+	probeConflictLimit  = 15
+	probeConflictWindow = 10 * time.Second
+	probeConflictDelay  = 5 * time.Second
+)
+
+// prober tracks the unique records the server is probing for
+type prober struct {
+	records []dns.RR
+
+	// conflictCh receives the names that another host already answers for,
+	// and deferCh is signalled when a simultaneous probe wins the tie-break
+	conflictCh chan string
+	deferCh    chan struct{}
+}
+
+// newProber returns a prober for the given unique records
+func newProber(records []dns.RR) *prober {
+	return &prober{
+		records:    records,
+		conflictCh: make(chan string, 1),
+		deferCh:    make(chan struct{}, 1),
+	}
+}
+
+// owns checks if name belongs to one of the records being probed
+func (p *prober) owns(name string) bool {
+	return slices.ContainsFunc(p.records, func(rr dns.RR) bool {
+		return strings.EqualFold(rr.Header().Name, name)
+	})
+}
+
+// named returns the records being probed that have the given name
+func (p *prober) named(name string) []dns.RR {
+	var recs []dns.RR
+	for _, rr := range p.records {
+		if strings.EqualFold(rr.Header().Name, name) {
+			recs = append(recs, rr)
+		}
+	}
+	return recs
+}
+
+// messages builds the probe queries: a question of type ANY for every name
+// being probed, with the proposed records in the authority section. The names
+// are spread over as many queries as needed to keep each of them within
+// maxPacketSize, the records of a name always going with its question so
+// that a simultaneous probe can be compared name by name.
+func (p *prober) messages(unicast bool) []*dns.Msg {
+	var msgs []*dns.Msg
+	m := newProbe()
+	for _, rr := range p.records {
+		name := rr.Header().Name
+		if slices.ContainsFunc(msgs, func(m *dns.Msg) bool { return hasQuestion(m, name) }) || hasQuestion(m, name) {
+			continue
+		}
+		q := dns.Question{Name: name, Qtype: dns.TypeANY, Qclass: dns.ClassINET}
+		if unicast {
+			q.Qclass |= 1 << 15
+		}
+		n := len(m.Ns)
+		m.Question = append(m.Question, q)
+		for _, rr := range p.named(name) {
+			m.Ns = append(m.Ns, withoutCacheFlush(rr))
+		}
+		if m.Len() > maxPacketSize && len(m.Question) > 1 {
+			records := m.Ns[n:]
+			m.Question, m.Ns = m.Question[:len(m.Question)-1], m.Ns[:n]
+			msgs = append(msgs, m)
+			m = newProbe()
+			m.Question, m.Ns = []dns.Question{q}, records
+		}
+	}
+	return append(msgs, m)
+}
+
+// newProbe returns an empty probe query
+func newProbe() *dns.Msg {
+	m := new(dns.Msg)
+	m.RecursionDesired = false
+	m.Compress = true
+	return m
+}
+
+// hasQuestion checks if m asks about name
+func hasQuestion(m *dns.Msg, name string) bool {
+	return slices.ContainsFunc(m.Question, func(q dns.Question) bool { return strings.EqualFold(q.Name, name) })
+}
+
+// handleResponse checks a response from another host for records that
+// conflict with the ones being probed. "A conflicting Multicast DNS response
+// is one containing any Resource Record with the same name, rrtype, and
+// rrclass as a unique record being probed, but inconsistent rdata." NSEC
+// records only list the types that exist, so they never conflict.
+func (p *prober) handleResponse(resp *dns.Msg) {
+	for _, rr := range append(resp.Answer, resp.Extra...) {
+		if rr.Header().Rrtype == dns.TypeNSEC {
+			continue
+		}
+		ours := slices.DeleteFunc(p.named(rr.Header().Name), func(ours dns.RR) bool {
+			return ours.Header().Rrtype != rr.Header().Rrtype ||
+				ours.Header().Class&^cacheFlush != rr.Header().Class&^cacheFlush
+		})
+		if len(ours) == 0 || slices.ContainsFunc(ours, func(ours dns.RR) bool { return isDuplicate(ours, rr) }) {
+			continue
+		}
+		select {
+		case p.conflictCh <- ours[0].Header().Name:
+		default:
+		}
+		return
+	}
+}
+
+// handleProbe breaks the tie with another host probing for the same names at
+// the same time, as per section 8.2 of RFC 6762
+func (p *prober) handleProbe(query *dns.Msg) {
+	for _, q := range query.Question {
+		if !p.owns(q.Name) {
+			continue
+		}
+		var theirs []dns.RR
+		for _, rr := range query.Ns {
+			if strings.EqualFold(rr.Header().Name, q.Name) {
+				theirs = append(theirs, rr)
+			}
+		}
+		if len(theirs) == 0 {
+			continue
+		}
+		if compareRecordSets(p.named(q.Name), theirs) < 0 {
+			select {
+			case p.deferCh <- struct{}{}:
+			default:
+			}
+			return
+		}
+	}
+}
+
+// compareRecordSets compares two sets of records lexicographically, by class,
+// type and then raw rdata, after sorting each set. A set that runs out of
+// records first is the lesser one.
+func compareRecordSets(a, b []dns.RR) int {
+	a = slices.SortedFunc(slices.Values(a), compareRecords)
+	b = slices.SortedFunc(slices.Values(b), compareRecords)
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := compareRecords(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return len(a) - len(b)
+}
+
+// compareRecords compares two records by class, type and then raw rdata
+func compareRecords(a, b dns.RR) int {
+	ha, hb := a.Header(), b.Header()
+	if c := int(ha.Class&^cacheFlush) - int(hb.Class&^cacheFlush); c != 0 {
+		return c
+	}
+	if c := int(ha.Rrtype) - int(hb.Rrtype); c != 0 {
+		return c
+	}
+	return bytes.Compare(rdata(a), rdata(b))
+}
+
+// rdata returns the uncompressed wire format of a record's data
+func rdata(rr dns.RR) []byte {
+	// Packing sets the rdata length in the header, so work on a copy
+	rr = dns.Copy(rr)
+	buf := make([]byte, dns.Len(rr))
+	off, err := dns.PackRR(rr, buf, 0, nil, false)
+	if err != nil {
+		return nil
+	}
+	hdr := make([]byte, 256)
+	hoff, err := dns.PackDomainName(rr.Header().Name, hdr, 0, nil, false)
+	if err != nil {
+		return nil
+	}
+	// Skip the name, type, class, TTL and rdata length
+	return buf[hoff+10 : off]
+}
+
+// probe claims the unique names of the zone before the server answers for
+// them, as per section 8.1 of RFC 6762.
+func (s *Server) probe(ctx context.Context) error {
+	zone, ok := s.config.Zone.(PublishingZone)
+	if !ok {
+		return nil
+	}
+	return s.probeZone(ctx, zone)
+}
+
+// claim claims the unique names a ClaimingZone is about to add while the
+// server is running. The server keeps answering for its other names.
+func (s *Server) claim(zone PublishingZone) error {
+	s.claimLock.Lock()
+	defer s.claimLock.Unlock()
+	if s.shutdown.Load() == 1 {
+		return fmt.Errorf("mdns: server is shut down")
+	}
+	return s.probeZone(context.Background(), zone)
+}
+
+// probeZone probes for the unique records of zone. If another host already
+// answers for one of the names and the zone implements RenamingZone, the
+// zone picks a new name and probing starts again. Otherwise probeZone fails.
+func (s *Server) probeZone(ctx context.Context, zone PublishingZone) error {
+	defer s.probing.Store(nil)
+
+	var conflicts []time.Time
+	wait := rand.N(probeWait)
+	for {
+		var records []dns.RR
+		for _, rr := range zone.PublishedRecords() {
+			if isUnique(rr) {
+				records = append(records, rr)
+			}
+		}
+		if len(records) == 0 {
+			return nil
+		}
+
+		name, err := s.probeRecords(ctx, newProber(records), wait)
+		if err != nil || name == "" {
+			return err
+		}
+
+		renaming, ok := zone.(RenamingZone)
+		if !ok {
+			return fmt.Errorf("mdns: name %s is already in use", name)
+		}
+		newName, err := renaming.Rename(name)
+		if err != nil {
+			return fmt.Errorf("mdns: failed to rename %s: %v", name, err)
+		}
+		s.config.Logger.Printf("[INFO] mdns: Name %s is already in use, renamed to %s", name, newName)
+		if s.config.OnRename != nil {
+			s.config.OnRename(name, newName)
+		}
+
+		// "If fifteen conflicts occur within any ten-second period, then the
+		// host MUST wait at least five seconds before each successive
+		// additional probe attempt."
+		now := time.Now()
+		conflicts = slices.DeleteFunc(append(conflicts, now), func(t time.Time) bool {
+			return now.Sub(t) > probeConflictWindow
+		})
+		wait = 0
+		if len(conflicts) >= probeConflictLimit {
+			wait = probeConflictDelay
+		}
+	}
+}
+
+// probeRecords sends the probes for one set of records after an initial
+// wait. It returns the first name found to be in use by another host, or an
+// empty name once the records are claimed.
+func (s *Server) probeRecords(ctx context.Context, p *prober, wait time.Duration) (string, error) {
+	s.probing.Store(p)
+	for sent := 0; ; {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-s.shutdownCh:
+			return "", fmt.Errorf("mdns: server shut down while probing")
+		case name := <-p.conflictCh:
+			return name, nil
+		case <-p.deferCh:
+			// Another host is probing for the same names and won the
+			// tie-break, so start again once it has had time to claim them
+			sent = 0
+			wait = probeDefer
+			continue
+		case <-time.After(wait):
+		}
+
+		if sent == probeCount {
+			return "", nil
+		}
+		// Only ask for unicast answers to the first probe, so that hosts on
+		// the same machine see the answers to the rest
+		for _, m := range p.messages(sent == 0) {
+			if err := s.multicast(m); err != nil {
+				return "", fmt.Errorf("mdns: failed to send probe: %v", err)
+			}
+		}
+		sent++
+		wait = probeInterval
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright IBM Corp. 2014, 2026
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestCompareRecordSets(t *testing.T) {
+	a := makeA("testhost.local.", net.IPv4(169, 254, 99, 200), defaultTTL)
+	b := makeA("testhost.local.", net.IPv4(169, 254, 200, 50), defaultTTL)
+	txt := &dns.TXT{
+		Hdr: dns.RR_Header{Name: "testhost.local.", Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: defaultTTL},
+		Txt: []string{"a"},
+	}
+
+	for _, test := range []struct {
+		name string
+		a, b []dns.RR
+		want int
+	}{
+		{"identical sets", []dns.RR{a}, []dns.RR{a}, 0},
+		{"rdata decides", []dns.RR{a}, []dns.RR{b}, -1},
+		{"type decides before rdata", []dns.RR{txt}, []dns.RR{b}, 1},
+		{"order doesn't matter", []dns.RR{txt, a}, []dns.RR{a, txt}, 0},
+		{"longer set wins", []dns.RR{a, txt}, []dns.RR{a}, 1},
+	} {
+		got := compareRecordSets(test.a, test.b)
+		if (got < 0) != (test.want < 0) || (got > 0) != (test.want > 0) {
+			t.Errorf("%s: got %d, want %d", test.name, got, test.want)
+		}
+	}
+}
+
+func TestProber_SimultaneousProbe(t *testing.T) {
+	ours := makeA("testhost.local.", net.IPv4(169, 254, 99, 200), defaultTTL)
+	p := newProber([]dns.RR{ours})
+
+	probe := func(rr dns.RR) *dns.Msg {
+		m := new(dns.Msg)
+		m.SetQuestion("testhost.local.", dns.TypeANY)
+		m.Ns = []dns.RR{rr}
+		return m
+	}
+
+	// Our own probe and a probe we win against are both ignored
+	p.handleProbe(probe(ours))
+	p.handleProbe(probe(makeA("testhost.local.", net.IPv4(169, 254, 1, 1), defaultTTL)))
+	select {
+	case <-p.deferCh:
+		t.Fatalf("expected no deferral")
+	default:
+	}
+
+	p.handleProbe(probe(makeA("testhost.local.", net.IPv4(169, 254, 200, 50), defaultTTL)))
+	select {
+	case <-p.deferCh:
+	default:
+		t.Fatalf("expected the probe to be deferred")
+	}
+}
+
+func TestProber_Messages(t *testing.T) {
+	ip := net.IPv4(192, 168, 0, 42)
+	var services []*MDNSService
+	for i := 0; i < 12; i++ {
+		services = append(services, makeRegistryService(t, fmt.Sprintf("service %d with a long instance name", i), "_probe._tcp", ip))
+	}
+	r, err := NewServiceRegistry(services...)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var records []dns.RR
+	for _, rr := range r.PublishedRecords() {
+		if isUnique(rr) {
+			records = append(records, rr)
+		}
+	}
+	p := newProber(records)
+
+	msgs := p.messages(true)
+	if len(msgs) < 2 {
+		t.Fatalf("expected the probe to be split, got %d messages", len(msgs))
+	}
+	probed := 0
+	for _, m := range msgs {
+		if m.Len() > maxPacketSize {
+			t.Fatalf("probe of %d bytes is larger than %d", m.Len(), maxPacketSize)
+		}
+		// Every name goes with all of its records
+		for _, q := range m.Question {
+			var ns []dns.RR
+			for _, rr := range m.Ns {
+				if rr.Header().Name == q.Name {
+					ns = append(ns, rr)
+				}
+			}
+			if len(ns) != len(p.named(q.Name)) {
+				t.Fatalf("expected every record of %s in its probe: %v", q.Name, m)
+			}
+		}
+		probed += len(m.Ns)
+	}
+	if probed != len(records) {
+		t.Fatalf("probed %d records, want %d", probed, len(records))
+	}
+}
+
+func TestProber_Conflict(t *testing.T) {
+	ip := net.IPv4(192, 168, 0, 42)
+	a, err := NewMDNSService("a", "_http._tcp", "local.", "h.local.", 80, []net.IP{ip}, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	b, err := NewMDNSService("b", "_http._tcp", "local.", "h.local.", 80, []net.IP{ip}, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var unique []dns.RR
+	for _, rr := range b.PublishedRecords() {
+		if isUnique(rr) {
+			unique = append(unique, rr)
+		}
+	}
+	p := newProber(unique)
+
+	// A response from a service sharing the host carries the same address
+	// and an NSEC record for the host, neither of which conflict
+	q := dns.Question{Name: "_http._tcp.local.", Qtype: dns.TypePTR}
+	p.handleResponse(&dns.Msg{Answer: a.Records(q), Extra: a.AdditionalRecords(q)})
+	select {
+	case name := <-p.conflictCh:
+		t.Fatalf("expected no conflict, got one on %s", name)
+	default:
+	}
+
+	// A different address for the host does
+	p.handleResponse(&dns.Msg{Answer: []dns.RR{makeA("h.local.", net.IPv4(192, 168, 0, 43), defaultTTL)}})
+	select {
+	case name := <-p.conflictCh:
+		if name != "h.local." {
+			t.Fatalf("bad conflict: %s", name)
+		}
+	default:
+		t.Fatalf("expected a conflict")
+	}
+}
+
+func TestServer_ProbeConflict(t *testing.T) {
+	serv, err := NewServer(&Config{Zone: makeServiceWithServiceName(t, "_conflict._tcp")})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer func() {
+		if err := serv.Shutdown(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}()
+
+	s, err := NewMDNSService("hostname", "_conflict._tcp", "local.", "testhost.", 8080,
+		[]net.IP{net.IP([]byte{192, 168, 0, 42})}, []string{"Local web server"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	// Without renaming, the conflict fails the server
+	_, err = NewServer(&Config{Zone: struct{ PublishingZone }{s}})
+	if err == nil || !strings.Contains(err.Error(), "hostname._conflict._tcp.local.") {
+		t.Fatalf("expected a conflict for the instance name, got: %v", err)
+	}
+}
+
+func TestServer_ProbeRename(t *testing.T) {
+	serv, err := NewServer(&Config{Zone: makeServiceWithServiceName(t, "_rename._tcp")})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer func() {
+		if err := serv.Shutdown(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}()
+
+	s, err := NewMDNSService("hostname", "_rename._tcp", "local.", "testhost.", 8080,
+		[]net.IP{net.IP([]byte{192, 168, 0, 43})}, []string{"Local web server"})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	renamed := make(map[string]string)
+	serv2, err := NewServer(&Config{
+		Zone: s,
+		OnRename: func(oldName, newName string) {
+			renamed[oldName] = newName
+		},
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer func() {
+		if err := serv2.Shutdown(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}()
+
+	if got, want := s.Instance, "hostname (2)"; got != want {
+		t.Fatalf("got instance %q, want %q", got, want)
+	}
+	if got, want := s.HostName, "testhost-2."; got != want {
+		t.Fatalf("got host name %q, want %q", got, want)
+	}
+	if got, want := renamed["hostname._rename._tcp.local."], "hostname (2)._rename._tcp.local."; got != want {
+		t.Fatalf("got instance renamed to %q, want %q", got, want)
+	}
+	if got, want := renamed["testhost."], "testhost-2."; got != want {
+		t.Fatalf("got host renamed to %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,324 @@
+// Copyright IBM Corp. 2014, 2026
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// ServiceRegistry is a Zone that hosts any number of services. Services can
+// be registered and unregistered while the server is running, in which case
+// the server probes for the new names, announces the new records and sends
+// goodbyes for the removed ones. The registry answers the service type
+// enumeration query with each type once, and services on the same host share
+// its address records.
+type ServiceRegistry struct {
+	mu       sync.RWMutex
+	services []*registeredService
+
+	// claim probes for the names of services registered while a server is
+	// running
+	claim func(zone PublishingZone) error
+
+	// published holds the records published when the watchers were last
+	// told about a change
+	published []dns.RR
+
+	watchers    map[int]func(changed, removed []dns.RR)
+	nextWatcher int
+}
+
+// registeredService is a service in a registry
+type registeredService struct {
+	service *MDNSService
+
+	// unwatch stops forwarding the changes of the service
+	unwatch func()
+}
+
+// NewServiceRegistry returns a registry hosting the given services.
+func NewServiceRegistry(services ...*MDNSService) (*ServiceRegistry, error) {
+	r := &ServiceRegistry{}
+	for _, s := range services {
+		if err := r.Register(s); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Register adds a service to the registry. It fails if a service with the
+// same instance name is already registered. While a server is running,
+// Register first probes for the new names of the service, which may rename
+// the service, and fails if they can't be claimed.
+func (r *ServiceRegistry) Register(s *MDNSService) error {
+	r.mu.RLock()
+	claim := r.claim
+	err := r.checkInstance(s)
+	var published []string
+	for _, rr := range r.published {
+		published = append(published, rr.Header().Name)
+	}
+	r.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	if claim != nil {
+		if err := claim(&pendingService{MDNSService: s, published: published}); err != nil {
+			return err
+		}
+	}
+
+	r.update(func() {
+		if err = r.checkInstance(s); err != nil {
+			return
+		}
+		unwatch := s.Watch(func(changed, removed []dns.RR) {
+			r.update(func() {})
+		})
+		r.services = append(r.services, &registeredService{service: s, unwatch: unwatch})
+	})
+	return err
+}
+
+// checkInstance returns an error if a service with the same instance name
+// as s is registered
+func (r *ServiceRegistry) checkInstance(s *MDNSService) error {
+	instance, _ := s.names()
+	for _, reg := range r.services {
+		if name, _ := reg.service.names(); name == instance {
+			return fmt.Errorf("mdns: instance %s is already registered", instance)
+		}
+	}
+	return nil
+}
+
+// SetClaimer registers fn to probe for the names of the services registered
+// from now on. The server calls it once it has claimed the names of the
+// services already registered.
+func (r *ServiceRegistry) SetClaimer(fn func(zone PublishingZone) error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.claim = fn
+}
+
+// Unregister removes a service from the registry. It fails if the service
+// isn't registered.
+func (r *ServiceRegistry) Unregister(s *MDNSService) error {
+	err := fmt.Errorf("mdns: service is not registered")
+	r.update(func() {
+		i := slices.IndexFunc(r.services, func(reg *registeredService) bool { return reg.service == s })
+		if i < 0 {
+			return
+		}
+		r.services[i].unwatch()
+		r.services = slices.Delete(r.services, i, i+1)
+		err = nil
+	})
+	return err
+}
+
+// update applies a change to the registry and tells the watchers which
+// records changed
+func (r *ServiceRegistry) update(change func()) {
+	r.mu.Lock()
+	change()
+	next := r.publishedRecords()
+	changed, removed := diffRecords(r.published, next)
+	r.published = next
+	watchers := make([]func(changed, removed []dns.RR), 0, len(r.watchers))
+	for _, fn := range r.watchers {
+		watchers = append(watchers, fn)
+	}
+	r.mu.Unlock()
+
+	if len(changed) == 0 && len(removed) == 0 {
+		return
+	}
+	for _, fn := range watchers {
+		fn(changed, removed)
+	}
+}
+
+// Watch registers fn to be called after services are registered,
+// unregistered or changed.
+func (r *ServiceRegistry) Watch(fn func(changed, removed []dns.RR)) (stop func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.watchers == nil {
+		r.watchers = make(map[int]func(changed, removed []dns.RR))
+	}
+	id := r.nextWatcher
+	r.nextWatcher++
+	r.watchers[id] = fn
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		delete(r.watchers, id)
+	}
+}
+
+// Rename renames the service instance with the given name, or the host of
+// every service on the host with the given name.
+func (r *ServiceRegistry) Rename(name string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var newName string
+	for _, reg := range r.services {
+		if instance, host := reg.service.names(); instance != name && host != name {
+			continue
+		}
+		renamed, err := reg.service.Rename(name)
+		if err != nil {
+			return "", err
+		}
+		newName = renamed
+	}
+	if newName == "" {
+		return "", fmt.Errorf("%s is not a name of any registered service", name)
+	}
+	// The server claims the new names before announcing them
+	r.published = r.publishedRecords()
+	return newName, nil
+}
+
+// Records returns DNS records in response to a DNS question.
+func (r *ServiceRegistry) Records(q dns.Question) []dns.RR {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.isHost(q.Name) {
+		all := r.hostRecords(q.Name)
+		var recs []dns.RR
+		for _, rr := range all {
+			if q.Qtype == dns.TypeANY || rr.Header().Rrtype == q.Qtype {
+				recs = append(recs, rr)
+			}
+		}
+		if len(recs) == 0 {
+			return []dns.RR{nsec(q.Name, recordTypes(all)...)}
+		}
+		return recs
+	}
+
+	var recs []dns.RR
+	for _, reg := range r.services {
+		recs = append(recs, reg.service.Records(q)...)
+	}
+	return mergeRecords(recs)
+}
+
+// AdditionalRecords returns the records to add to the answers to a DNS
+// question, using the address records of every service on a host.
+func (r *ServiceRegistry) AdditionalRecords(q dns.Question) []dns.RR {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.isHost(q.Name) {
+		var other uint16
+		switch q.Qtype {
+		case dns.TypeA:
+			other = dns.TypeAAAA
+		case dns.TypeAAAA:
+			other = dns.TypeA
+		default:
+			return nil
+		}
+		all := r.hostRecords(q.Name)
+		var recs []dns.RR
+		for _, rr := range all {
+			if rr.Header().Rrtype == other {
+				recs = append(recs, rr)
+			}
+		}
+		return append(recs, nsec(q.Name, recordTypes(all)...))
+	}
+
+	// Replace the host records of each service with the shared ones
+	var recs []dns.RR
+	var hosts []string
+	for _, reg := range r.services {
+		for _, rr := range reg.service.AdditionalRecords(q) {
+			name := rr.Header().Name
+			if !r.isHost(name) {
+				recs = append(recs, rr)
+			} else if !slices.Contains(hosts, name) {
+				hosts = append(hosts, name)
+			}
+		}
+	}
+	for _, host := range hosts {
+		all := r.hostRecords(host)
+		recs = append(recs, all...)
+		recs = append(recs, nsec(host, recordTypes(all)...))
+	}
+	return mergeRecords(recs)
+}
+
+// PublishedRecords returns every record the registered services publish.
+func (r *ServiceRegistry) PublishedRecords() []dns.RR {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.publishedRecords()
+}
+
+func (r *ServiceRegistry) publishedRecords() []dns.RR {
+	var recs []dns.RR
+	for _, reg := range r.services {
+		recs = append(recs, reg.service.PublishedRecords()...)
+	}
+	return mergeRecords(recs)
+}
+
+// isHost checks if name is the host name of a registered service
+func (r *ServiceRegistry) isHost(name string) bool {
+	return slices.ContainsFunc(r.services, func(reg *registeredService) bool {
+		_, host := reg.service.names()
+		return host == name
+	})
+}
+
+// hostRecords returns the A and AAAA records of every service on a host
+func (r *ServiceRegistry) hostRecords(host string) []dns.RR {
+	var recs []dns.RR
+	for _, reg := range r.services {
+		if _, h := reg.service.names(); h != host {
+			continue
+		}
+		for _, rr := range reg.service.Records(dns.Question{Name: host, Qtype: dns.TypeANY}) {
+			if rrtype := rr.Header().Rrtype; rrtype == dns.TypeA || rrtype == dns.TypeAAAA {
+				recs = append(recs, rr)
+			}
+		}
+	}
+	return mergeRecords(recs)
+}
+
+// pendingService is a service waiting to be registered while its names are
+// probed for. It only publishes the names the registry doesn't already
+// publish, such as the host name of another service.
+type pendingService struct {
+	*MDNSService
+	published []string
+}
+
+// PublishedRecords returns the records of the service with new names.
+func (p *pendingService) PublishedRecords() []dns.RR {
+	return slices.DeleteFunc(p.MDNSService.PublishedRecords(), func(rr dns.RR) bool {
+		return slices.Contains(p.published, rr.Header().Name)
+	})
+}
+
+// mergeRecords drops the records that repeat an earlier one
+func mergeRecords(records []dns.RR) []dns.RR {
+	var merged []dns.RR
+	for _, rr := range records {
+		if !slices.ContainsFunc(merged, func(old dns.RR) bool { return isDuplicate(old, rr) }) {
+			merged = append(merged, rr)
+		}
+	}
+	return merged
+}
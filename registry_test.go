@@ -0,0 +1,242 @@
+// Copyright IBM Corp. 2014, 2026
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func makeRegistryService(t *testing.T, instance, service string, ips ...net.IP) *MDNSService {
+	s, err := NewMDNSService(instance, service, "local.", "testhost.local.", 80, ips, []string{instance})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return s
+}
+
+func TestServiceRegistry_Enumeration(t *testing.T) {
+	ip := net.IPv4(192, 168, 0, 42)
+	r, err := NewServiceRegistry(
+		makeRegistryService(t, "one", "_http._tcp", ip),
+		makeRegistryService(t, "two", "_http._tcp", ip),
+		makeRegistryService(t, "shell", "_ssh._tcp", ip),
+	)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	recs := r.Records(dns.Question{Name: "_services._dns-sd._udp.local.", Qtype: dns.TypePTR})
+	if len(recs) != 2 {
+		t.Fatalf("expected each service type once, got: %v", recs)
+	}
+	recs = r.Records(dns.Question{Name: "_http._tcp.local.", Qtype: dns.TypePTR})
+	if len(recs) != 2 {
+		t.Fatalf("expected both instances, got: %v", recs)
+	}
+}
+
+func TestServiceRegistry_SharedHost(t *testing.T) {
+	r, err := NewServiceRegistry(
+		makeRegistryService(t, "one", "_http._tcp", net.IPv4(192, 168, 0, 42)),
+		makeRegistryService(t, "two", "_http._tcp", net.IPv4(192, 168, 0, 43)),
+	)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if recs := r.Records(dns.Question{Name: "testhost.local.", Qtype: dns.TypeA}); len(recs) != 2 {
+		t.Fatalf("expected the addresses of both services, got: %v", recs)
+	}
+	recs := r.Records(dns.Question{Name: "testhost.local.", Qtype: dns.TypeAAAA})
+	if len(recs) != 1 {
+		t.Fatalf("expected a single NSEC record, got: %v", recs)
+	}
+	if nsec, ok := recs[0].(*dns.NSEC); !ok || len(nsec.TypeBitMap) != 1 || nsec.TypeBitMap[0] != dns.TypeA {
+		t.Fatalf("bad NSEC record: %v", recs[0])
+	}
+
+	// Each instance comes with every address of the host
+	extra := r.AdditionalRecords(dns.Question{Name: "_http._tcp.local.", Qtype: dns.TypePTR})
+	var addrs, nsecs int
+	for _, rr := range extra {
+		switch rr := rr.(type) {
+		case *dns.A:
+			addrs++
+		case *dns.NSEC:
+			if rr.Hdr.Name == "testhost.local." {
+				nsecs++
+			}
+		}
+	}
+	if addrs != 2 || nsecs != 1 {
+		t.Fatalf("expected the host records once, got: %v", extra)
+	}
+}
+
+func TestServiceRegistry_RegisterUnregister(t *testing.T) {
+	ip := net.IPv4(192, 168, 0, 42)
+	one := makeRegistryService(t, "one", "_http._tcp", ip)
+	two := makeRegistryService(t, "two", "_http._tcp", ip)
+	r, err := NewServiceRegistry(one)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var changed, removed []dns.RR
+	r.Watch(func(c, rm []dns.RR) {
+		changed, removed = c, rm
+	})
+
+	if err := r.Register(two); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	// The PTR, SRV and TXT records are new, but the host and enumeration
+	// records were already published
+	if len(changed) != 3 || len(removed) != 0 {
+		t.Fatalf("bad change: %v, %v", changed, removed)
+	}
+	if err := r.Register(makeRegistryService(t, "two", "_http._tcp", ip)); err == nil {
+		t.Fatalf("expected an error registering the same instance twice")
+	}
+
+	// Changes to a registered service are passed on
+	two.SetTXT([]string{"updated"})
+	if len(changed) != 1 || len(removed) != 1 {
+		t.Fatalf("bad change: %v, %v", changed, removed)
+	}
+
+	if err := r.Unregister(one); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(changed) != 0 || len(removed) != 3 {
+		t.Fatalf("bad change: %v, %v", changed, removed)
+	}
+	if err := r.Unregister(one); err == nil {
+		t.Fatalf("expected an error unregistering a service twice")
+	}
+
+	// Unregistered services are no longer watched
+	changed, removed = nil, nil
+	one.SetTXT([]string{"updated"})
+	if changed != nil || removed != nil {
+		t.Fatalf("expected no notification, got: %v, %v", changed, removed)
+	}
+}
+
+func TestServiceRegistry_Rename(t *testing.T) {
+	ip := net.IPv4(192, 168, 0, 42)
+	one := makeRegistryService(t, "one", "_http._tcp", ip)
+	two := makeRegistryService(t, "two", "_http._tcp", ip)
+	r, err := NewServiceRegistry(one, two)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	name, err := r.Rename("testhost.local.")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if name != "testhost-2.local." || one.HostName != name || two.HostName != name {
+		t.Fatalf("expected every service on the host to be renamed: %s, %s, %s", name, one.HostName, two.HostName)
+	}
+	if name, err := r.Rename("one._http._tcp.local."); err != nil || name != "one (2)._http._tcp.local." {
+		t.Fatalf("bad rename: %s, %v", name, err)
+	}
+	if _, err := r.Rename("random."); err == nil {
+		t.Fatalf("expected an error renaming a name no service owns")
+	}
+}
+
+func TestServer_Registry(t *testing.T) {
+	r, err := NewServiceRegistry(makeServiceWithServiceName(t, "_registry._tcp"))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	serv, err := NewServer(&Config{Zone: r})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer func() {
+		if err := serv.Shutdown(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}()
+
+	// Services registered while the server runs are probed for, then
+	// answered for
+	if err := r.Register(makeRegistryService(t, "late", "_registry._tcp", net.IPv4(192, 168, 0, 42))); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	entries := make(chan *ServiceEntry, 4)
+	err = Query(&QueryParam{
+		Service:     "_registry._tcp",
+		Domain:      "local",
+		Timeout:     100 * time.Millisecond,
+		Entries:     entries,
+		DisableIPv6: true,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	close(entries)
+	found := make(map[string]bool)
+	for e := range entries {
+		found[e.Name] = true
+	}
+	if !found["hostname._registry._tcp.local."] || !found["late._registry._tcp.local."] {
+		t.Fatalf("expected both instances, got: %v", found)
+	}
+}
+
+func TestServer_RegistryConflict(t *testing.T) {
+	serv, err := NewServer(&Config{Zone: makeServiceWithServiceName(t, "_claim._tcp")})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer func() {
+		if err := serv.Shutdown(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}()
+
+	r, err := NewServiceRegistry(makeRegistryService(t, "other", "_claim._tcp", net.IPv4(192, 168, 0, 43)))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	renamed := make(map[string]string)
+	serv2, err := NewServer(&Config{
+		Zone: r,
+		OnRename: func(oldName, newName string) {
+			renamed[oldName] = newName
+		},
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer func() {
+		if err := serv2.Shutdown(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}()
+
+	// The instance name of a late registration is already in use by the
+	// first server
+	s, err := NewMDNSService("hostname", "_claim._tcp", "local.", "testhost.local.", 80,
+		[]net.IP{net.IPv4(192, 168, 0, 43)}, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := r.Register(s); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if s.Instance != "hostname (2)" || renamed["hostname._claim._tcp.local."] != "hostname (2)._claim._tcp.local." {
+		t.Fatalf("expected the instance to be renamed before it was published: %s, %v", s.Instance, renamed)
+	}
+	if len(renamed) != 1 {
+		t.Fatalf("expected the shared host name not to be renamed: %v", renamed)
+	}
+}
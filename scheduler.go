@@ -0,0 +1,232 @@
+// Copyright IBM Corp. 2014, 2026
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"math/rand/v2"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// minResponseDelay and maxResponseDelay bound the random delay before
+	// multicasting answers that include shared records, as per section 6 of
+	// RFC 6762
+	minResponseDelay = 20 * time.Millisecond
+	maxResponseDelay = 120 * time.Millisecond
+
+	// multicastInterval is the shortest time between two multicasts of the
+	// same record, as per section 6 of RFC 6762
+	multicastInterval = time.Second
+)
+
+// responseScheduler delays the multicast answers that include shared
+// records, so that the answers of several responders are spread out and the
+// answers to queries arriving close together go out in one response. It is
+// safe for concurrent use.
+type responseScheduler struct {
+	// send multicasts a response to the group the query from addr arrived on
+	send func(resp *dns.Msg, from net.Addr) error
+
+	// errorf reports the responses that could not be sent
+	errorf func(format string, args ...any)
+
+	mu      sync.Mutex
+	stopped bool
+
+	// pending holds the answers waiting to be sent, keyed by address family
+	pending map[bool]*pendingResponse
+
+	// recent holds the records multicast within the last multicastInterval
+	recent []recentRecord
+}
+
+// recentRecord is a record that was recently multicast
+type recentRecord struct {
+	rr dns.RR
+	v4 bool
+	at time.Time
+}
+
+// pendingResponse is a multicast response waiting for its delay to pass
+type pendingResponse struct {
+	from   net.Addr
+	answer []dns.RR
+	extra  []dns.RR
+	timer  *time.Timer
+}
+
+// newResponseScheduler returns a scheduler sending responses with send
+func newResponseScheduler(send func(*dns.Msg, net.Addr) error, errorf func(string, ...any)) *responseScheduler {
+	return &responseScheduler{
+		send:    send,
+		errorf:  errorf,
+		pending: make(map[bool]*pendingResponse),
+	}
+}
+
+// schedule multicasts the answers to a query from addr, along with their
+// additional records. "In any case where
+// there may be multiple responses, such as queries where the answer is a
+// member of a shared resource record set, each responder SHOULD delay its
+// response by a random amount of time selected with uniform random
+// distribution in the range 20-120 ms." Answers made only of unique records
+// are sent immediately. Answers to a probe are not rate limited.
+func (r *responseScheduler) schedule(answer, extra []dns.RR, from net.Addr, probe bool) error {
+	v4 := from.(*net.UDPAddr).IP.To4() != nil
+	if !slices.ContainsFunc(answer, func(rr dns.RR) bool { return !isUnique(rr) }) {
+		answer = r.limit(answer, v4, probe)
+		if len(answer) == 0 {
+			return nil
+		}
+		for _, resp := range responsePackets(answer, r.limit(extra, v4, probe)) {
+			if err := r.send(resp, from); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped {
+		return nil
+	}
+	pending, ok := r.pending[v4]
+	if !ok {
+		// Later answers for the same group join this response rather than
+		// waiting for their own delay
+		pending = &pendingResponse{from: from}
+		delay := minResponseDelay + rand.N(maxResponseDelay-minResponseDelay)
+		pending.timer = time.AfterFunc(delay, func() { r.flush(v4, pending) })
+		r.pending[v4] = pending
+	}
+	for _, rr := range answer {
+		if !slices.ContainsFunc(pending.answer, func(old dns.RR) bool { return isDuplicate(old, rr) }) {
+			pending.answer = append(pending.answer, rr)
+		}
+	}
+	pending.extra = append(pending.extra, extra...)
+	return nil
+}
+
+// flush sends a pending response once its delay has passed
+func (r *responseScheduler) flush(v4 bool, pending *pendingResponse) {
+	r.mu.Lock()
+	if r.pending[v4] != pending {
+		r.mu.Unlock()
+		return
+	}
+	delete(r.pending, v4)
+	r.mu.Unlock()
+
+	answer := r.limit(pending.answer, v4, false)
+	if len(answer) == 0 {
+		return
+	}
+	extra := r.limit(trimAdditional(pending.extra, answer, nil), v4, false)
+	for _, resp := range responsePackets(answer, extra) {
+		if err := r.send(resp, pending.from); err != nil {
+			r.errorf("[ERR] mdns: error sending multicast response: %v", err)
+		}
+	}
+}
+
+// limit drops the answers that were multicast to the same group within the
+// last multicastInterval, and notes the rest as multicast now. "A Multicast
+// DNS responder MUST NOT (except in the one special case of answering probe
+// queries) multicast a record on a given interface until at least one second
+// has elapsed since the last time that record was multicast on that
+// particular interface."
+func (r *responseScheduler) limit(answer []dns.RR, v4, probe bool) []dns.RR {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	r.recent = slices.DeleteFunc(r.recent, func(recent recentRecord) bool {
+		return now.Sub(recent.at) >= multicastInterval
+	})
+
+	var allowed []dns.RR
+	for _, rr := range answer {
+		if !probe && slices.ContainsFunc(r.recent, func(recent recentRecord) bool {
+			return recent.v4 == v4 && isDuplicate(recent.rr, rr)
+		}) {
+			continue
+		}
+		allowed = append(allowed, rr)
+		r.recent = append(r.recent, recentRecord{rr: rr, v4: v4, at: now})
+	}
+	return allowed
+}
+
+// suppress drops the pending answers that another responder has multicast
+// to the same group, as per section 7.4 of RFC 6762: "If a host is planning
+// to send an answer, and it sees another host on the network send a response
+// message containing the same answer record, and the TTL in that record is
+// not less than the TTL this host would have given, then this host SHOULD
+// treat its own answer as having been sent, and not also send an identical
+// answer itself."
+func (r *responseScheduler) suppress(resp *dns.Msg, from net.Addr) {
+	v4 := from.(*net.UDPAddr).IP.To4() != nil
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pending, ok := r.pending[v4]
+	if !ok {
+		return
+	}
+	records := append(slices.Clip(resp.Answer), resp.Extra...)
+	sent := func(rr dns.RR) bool {
+		return slices.ContainsFunc(records, func(theirs dns.RR) bool {
+			return theirs.Header().Ttl >= rr.Header().Ttl && isDuplicate(theirs, rr)
+		})
+	}
+	pending.answer = slices.DeleteFunc(pending.answer, sent)
+	pending.extra = slices.DeleteFunc(pending.extra, sent)
+	if len(pending.answer) == 0 {
+		pending.timer.Stop()
+		delete(r.pending, v4)
+	}
+}
+
+// stop drops the pending responses, and any scheduled afterwards
+func (r *responseScheduler) stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stopped = true
+	for v4, pending := range r.pending {
+		pending.timer.Stop()
+		delete(r.pending, v4)
+	}
+}
+
+// responsePackets spreads answers over as many responses as needed to keep
+// each of them within maxPacketSize. The additional records that don't fit
+// in the last response are left out.
+func responsePackets(answer, extra []dns.RR) []*dns.Msg {
+	var packets []*dns.Msg
+	resp := newResponse(0, nil)
+	for _, rr := range answer {
+		resp.Answer = append(resp.Answer, rr)
+		if resp.Len() > maxPacketSize && len(resp.Answer) > 1 {
+			resp.Answer = resp.Answer[:len(resp.Answer)-1]
+			packets = append(packets, resp)
+			resp = newResponse(0, []dns.RR{rr})
+		}
+	}
+	if len(resp.Answer) == 0 {
+		return packets
+	}
+	for _, rr := range extra {
+		resp.Extra = append(resp.Extra, rr)
+		if resp.Len() > maxPacketSize {
+			resp.Extra = resp.Extra[:len(resp.Extra)-1]
+			break
+		}
+	}
+	return append(packets, resp)
+}
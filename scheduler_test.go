@@ -0,0 +1,183 @@
+// Copyright IBM Corp. 2014, 2026
+// SPDX-License-Identifier: MIT
+
+package mdns
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// sentResponse is a response captured from a responseScheduler
+type sentResponse struct {
+	resp *dns.Msg
+	at   time.Time
+}
+
+func testScheduler(t *testing.T) (*responseScheduler, chan sentResponse) {
+	sent := make(chan sentResponse, 16)
+	r := newResponseScheduler(func(resp *dns.Msg, from net.Addr) error {
+		sent <- sentResponse{resp: resp, at: time.Now()}
+		return nil
+	}, t.Logf)
+	t.Cleanup(r.stop)
+	return r, sent
+}
+
+func TestResponseScheduler_Unique(t *testing.T) {
+	r, sent := testScheduler(t)
+	from := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: mdnsPort}
+
+	if err := r.schedule([]dns.RR{makeUniqueA("testhost.local.", net.IPv4(192, 168, 0, 1), defaultTTL)}, nil, from, false); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	select {
+	case <-sent:
+	default:
+		t.Fatalf("expected unique answers to be sent immediately")
+	}
+}
+
+func TestResponseScheduler_Aggregate(t *testing.T) {
+	r, sent := testScheduler(t)
+	from := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: mdnsPort}
+
+	start := time.Now()
+	first := []dns.RR{makePTR("_http._tcp.local.", "one._http._tcp.local.", defaultTTL)}
+	second := []dns.RR{
+		makePTR("_http._tcp.local.", "one._http._tcp.local.", defaultTTL),
+		makePTR("_http._tcp.local.", "two._http._tcp.local.", defaultTTL),
+	}
+	for _, answer := range [][]dns.RR{first, second} {
+		if err := r.schedule(answer, nil, from, false); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	select {
+	case s := <-sent:
+		if elapsed := s.at.Sub(start); elapsed < minResponseDelay {
+			t.Fatalf("expected the response to be delayed, got it after %v", elapsed)
+		}
+		if len(s.resp.Answer) != 2 {
+			t.Fatalf("expected the answers to be combined: %v", s.resp)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for response")
+	}
+	select {
+	case s := <-sent:
+		t.Fatalf("expected a single response, got: %v", s.resp)
+	case <-time.After(2 * maxResponseDelay):
+	}
+}
+
+func TestResponseScheduler_Stop(t *testing.T) {
+	r, sent := testScheduler(t)
+	from := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: mdnsPort}
+
+	if err := r.schedule([]dns.RR{makePTR("_http._tcp.local.", "one._http._tcp.local.", defaultTTL)}, nil, from, false); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	r.stop()
+	select {
+	case s := <-sent:
+		t.Fatalf("expected no response after stopping, got: %v", s.resp)
+	case <-time.After(2 * maxResponseDelay):
+	}
+}
+
+func TestResponseScheduler_RateLimit(t *testing.T) {
+	r, sent := testScheduler(t)
+	from := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: mdnsPort}
+	answer := []dns.RR{makeUniqueA("testhost.local.", net.IPv4(192, 168, 0, 1), defaultTTL)}
+
+	for i := 0; i < 3; i++ {
+		if err := r.schedule(answer, nil, from, false); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	if len(sent) != 1 {
+		t.Fatalf("expected the record to be multicast once, got %d responses", len(sent))
+	}
+
+	// Probes are always answered
+	if err := r.schedule(answer, nil, from, true); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(sent) != 2 {
+		t.Fatalf("expected the probe to be answered")
+	}
+
+	// The limit is per address family
+	if err := r.schedule(answer, nil, &net.UDPAddr{IP: net.IPv6loopback, Port: mdnsPort}, false); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(sent) != 3 {
+		t.Fatalf("expected the record to be multicast over IPv6")
+	}
+}
+
+func TestResponseScheduler_DuplicateSuppression(t *testing.T) {
+	r, sent := testScheduler(t)
+	from := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: mdnsPort}
+	answer := []dns.RR{
+		makePTR("_http._tcp.local.", "one._http._tcp.local.", defaultTTL),
+		makePTR("_http._tcp.local.", "two._http._tcp.local.", defaultTTL),
+	}
+	if err := r.schedule(answer, nil, from, false); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// A copy with a lower TTL doesn't count
+	theirs := &dns.Msg{Answer: []dns.RR{makePTR("_http._tcp.local.", "one._http._tcp.local.", defaultTTL-1)}}
+	r.suppress(theirs, from)
+	theirs = &dns.Msg{Answer: []dns.RR{makePTR("_http._tcp.local.", "two._http._tcp.local.", defaultTTL)}}
+	r.suppress(theirs, from)
+
+	select {
+	case s := <-sent:
+		if len(s.resp.Answer) != 1 || s.resp.Answer[0].(*dns.PTR).Ptr != "one._http._tcp.local." {
+			t.Fatalf("expected the duplicate answer to be suppressed: %v", s.resp)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for response")
+	}
+
+	// Once every answer was sent by another host, nothing is left to send
+	if err := r.schedule(answer[1:], nil, &net.UDPAddr{IP: net.IPv6loopback, Port: mdnsPort}, false); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	r.suppress(theirs, &net.UDPAddr{IP: net.IPv6loopback, Port: mdnsPort})
+	select {
+	case s := <-sent:
+		t.Fatalf("expected no response, got: %v", s.resp)
+	case <-time.After(2 * maxResponseDelay):
+	}
+}
+
+func TestResponsePackets(t *testing.T) {
+	var answer []dns.RR
+	for i := 0; i < 100; i++ {
+		instance := fmt.Sprintf("instance-%d-with-a-fairly-long-name._http._tcp.local.", i)
+		answer = append(answer, makePTR("_http._tcp.local.", instance, defaultTTL))
+	}
+	packets := responsePackets(answer, nil)
+	if len(packets) < 2 {
+		t.Fatalf("expected the answers to be split, got %d packets", len(packets))
+	}
+	total := 0
+	for i, resp := range packets {
+		if resp.Len() > maxPacketSize {
+			t.Fatalf("packet %d is too large: %d bytes", i, resp.Len())
+		}
+		total += len(resp.Answer)
+	}
+	if total != len(answer) {
+		t.Fatalf("got %d answers, want %d", total, len(answer))
+	}
+}
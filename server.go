@@ -4,13 +4,20 @@
 package mdns
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"math/rand/v2"
 	"net"
+	"slices"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/miekg/dns"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
 )
 
 const (
@@ -18,6 +25,25 @@ const (
 	ipv6mdns              = "ff02::fb"
 	mdnsPort              = 5353
 	forceUnicastResponses = false
+
+	// truncatedDelay and truncatedJitter set how long the server waits for
+	// the rest of the known answers of a query with the TC bit set, as per
+	// section 7.2 of RFC 6762
+	truncatedDelay  = 400 * time.Millisecond
+	truncatedJitter = 100 * time.Millisecond
+
+	// announceCount is the number of unsolicited responses sent once the
+	// server has claimed its names, announceInterval apart
+	announceCount    = 2
+	announceInterval = time.Second
+
+	// maxPacketSize is the largest mDNS message we send: the Ethernet MTU,
+	// less the IPv6 and UDP headers, as per section 17 of RFC 6762
+	maxPacketSize = 1500 - 40 - 8
+
+	// legacyTTL is the longest TTL given in a response to a legacy querier,
+	// as per section 6.7 of RFC 6762
+	legacyTTL = 10
 )
 
 var (
@@ -47,6 +73,16 @@ type Config struct {
 
 	// Logger can optionally be set to use an alternative logger instead of the default.
 	Logger *log.Logger
+
+	// OnRename is optionally called when one of the zone's names is found to
+	// be in use by another host while probing, and the zone picked a new one.
+	// The zone must implement RenamingZone for this to happen.
+	OnRename func(oldName, newName string)
+
+	// DisableGoodbyes stops Shutdown from multicasting the zone's records
+	// with a TTL of zero, so that other hosts keep them cached as if the
+	// server had crashed.
+	DisableGoodbyes bool
 }
 
 // mDNS server is used to listen for mDNS queries and respond if we
@@ -59,10 +95,55 @@ type Server struct {
 
 	shutdown   atomic.Int32
 	shutdownCh chan struct{}
+
+	// truncated holds the queries with the TC bit set that are waiting for
+	// more known answers, keyed by source address
+	truncated     map[string]*truncatedQuery
+	truncatedLock sync.Mutex
+
+	// probing is set while the server probes for its unique names, and
+	// claimLock keeps the names added while the server runs from being
+	// probed for at the same time
+	probing   atomic.Pointer[prober]
+	claimLock sync.Mutex
+
+	// claimed is set once the server has claimed its names and announced
+	// its records
+	claimed atomic.Bool
+
+	// unwatch stops the notifications of changes to a WatchableZone
+	unwatch func()
+
+	// announceCh tells the announcer that the zone's records changed, and
+	// announceLock keeps announcements and goodbyes from interleaving
+	announceCh   chan struct{}
+	announceLock sync.Mutex
+
+	// announcing waits for the announcer to stop
+	announcing sync.WaitGroup
+
+	// responses delays and aggregates the multicast answers
+	responses *responseScheduler
+}
+
+// truncatedQuery is a query waiting for the rest of its known answers
+type truncatedQuery struct {
+	query *dns.Msg
+	from  net.Addr
+	timer *time.Timer
 }
 
-// NewServer is used to create a new mDNS server from a config
+// NewServer is used to create a new mDNS server from a config. If the zone
+// implements PublishingZone, NewServer first probes to make sure no other
+// host answers for the zone's unique names, and fails if one does. Once it
+// returns, the server announces the zone's records in the background.
 func NewServer(config *Config) (*Server, error) {
+	return NewServerContext(context.Background(), config)
+}
+
+// NewServerContext is the same as NewServer, however probing is abandoned if
+// ctx is cancelled.
+func NewServerContext(ctx context.Context, config *Config) (*Server, error) {
 	// Create the listeners
 	ipv4List, _ := net.ListenMulticastUDP("udp4", config.Iface, ipv4Addr)
 	ipv6List, _ := net.ListenMulticastUDP("udp6", config.Iface, ipv6Addr)
@@ -76,12 +157,43 @@ func NewServer(config *Config) (*Server, error) {
 		config.Logger = log.Default()
 	}
 
+	// Go disables multicast loopback on these sockets, but other responders
+	// on this host need to see our probes and answers. Multicasts must also
+	// leave through the interface the group was joined on.
+	if ipv4List != nil {
+		p := ipv4.NewPacketConn(ipv4List)
+		if err := p.SetMulticastLoopback(true); err != nil {
+			config.Logger.Printf("[ERR] mdns: Failed to enable udp4 multicast loopback: %v", err)
+		}
+		if config.Iface != nil {
+			if err := p.SetMulticastInterface(config.Iface); err != nil {
+				config.Logger.Printf("[ERR] mdns: Failed to set udp4 multicast interface: %v", err)
+			}
+		}
+	}
+	if ipv6List != nil {
+		p := ipv6.NewPacketConn(ipv6List)
+		if err := p.SetMulticastLoopback(true); err != nil {
+			config.Logger.Printf("[ERR] mdns: Failed to enable udp6 multicast loopback: %v", err)
+		}
+		if config.Iface != nil {
+			if err := p.SetMulticastInterface(config.Iface); err != nil {
+				config.Logger.Printf("[ERR] mdns: Failed to set udp6 multicast interface: %v", err)
+			}
+		}
+	}
+
 	s := &Server{
 		config:     config,
 		ipv4List:   ipv4List,
 		ipv6List:   ipv6List,
 		shutdownCh: make(chan struct{}),
+		truncated:  make(map[string]*truncatedQuery),
+		announceCh: make(chan struct{}, 1),
 	}
+	s.responses = newResponseScheduler(func(resp *dns.Msg, from net.Addr) error {
+		return s.sendResponse(resp, from, false)
+	}, config.Logger.Printf)
 
 	if ipv4List != nil {
 		go s.recv(s.ipv4List)
@@ -91,17 +203,50 @@ func NewServer(config *Config) (*Server, error) {
 		go s.recv(s.ipv6List)
 	}
 
+	if err := s.probe(ctx); err != nil {
+		if err := s.Shutdown(); err != nil {
+			config.Logger.Printf("[ERR] mdns: Failed to shut down: %v", err)
+		}
+		return nil, err
+	}
+	s.claimed.Store(true)
+	if zone, ok := config.Zone.(PublishingZone); ok {
+		s.announcing.Add(1)
+		go s.announce(zone)
+	}
+	if zone, ok := config.Zone.(WatchableZone); ok {
+		s.unwatch = zone.Watch(s.zoneChanged)
+	}
+	if zone, ok := config.Zone.(ClaimingZone); ok {
+		zone.SetClaimer(s.claim)
+	}
+
 	return s, nil
 }
 
-// Shutdown is used to shutdown the listener
+// Shutdown is used to shutdown the listener. Unless goodbyes are disabled,
+// it first tells other hosts to remove the zone's records from their caches.
 func (s *Server) Shutdown() error {
 	if !s.shutdown.CompareAndSwap(0, 1) {
 		// something else already closed us
 		return nil
 	}
 
+	if s.unwatch != nil {
+		s.unwatch()
+	}
+	if zone, ok := s.config.Zone.(ClaimingZone); ok && s.claimed.Load() {
+		zone.SetClaimer(nil)
+	}
+	s.responses.stop()
+
+	// Stop the announcer first, so that it can't publish the records again
+	// after the goodbyes
 	close(s.shutdownCh)
+	s.announcing.Wait()
+	if zone, ok := s.config.Zone.(PublishingZone); ok && s.claimed.Load() && !s.config.DisableGoodbyes {
+		s.goodbye(zone.PublishedRecords())
+	}
 
 	if s.ipv4List != nil {
 		s.ipv4List.Close()
@@ -137,11 +282,30 @@ func (s *Server) parsePacket(packet []byte, from net.Addr) error {
 		s.config.Logger.Printf("[ERR] mdns: Failed to unpack packet: %v", err)
 		return err
 	}
+	if msg.Response {
+		s.handleResponse(&msg, from)
+		return nil
+	}
 	return s.handleQuery(&msg, from)
 }
 
+// handleResponse is used to handle a response from another responder
+func (s *Server) handleResponse(resp *dns.Msg, from net.Addr) {
+	if p := s.probing.Load(); p != nil {
+		p.handleResponse(resp)
+		if !s.claimed.Load() {
+			return
+		}
+	}
+	s.responses.suppress(resp, from)
+}
+
 // handleQuery is used to handle an incoming query
 func (s *Server) handleQuery(query *dns.Msg, from net.Addr) error {
+	if s.shutdown.Load() == 1 {
+		// Don't undo the goodbyes
+		return nil
+	}
 	if query.Opcode != dns.OpcodeQuery {
 		// "In both multicast query and multicast response messages, the OPCODE MUST
 		// be zero on transmission (only standard queries are currently supported
@@ -156,78 +320,63 @@ func (s *Server) handleQuery(query *dns.Msg, from net.Addr) error {
 		return fmt.Errorf("mdns: received query with non-zero Rcode %v: %v", query.Rcode, *query)
 	}
 
-	// TODO(reddaly): Handle "TC (Truncated) Bit":
-	//    In query messages, if the TC bit is set, it means that additional
-	//    Known-Answer records may be following shortly.  A responder SHOULD
-	//    record this fact, and wait for those additional Known-Answer records,
-	//    before deciding whether to respond.  If the TC bit is clear, it means
-	//    that the querying host has no additional Known Answers.
-	if query.Truncated {
-		return fmt.Errorf("[ERR] mdns: support for DNS requests with high truncated bit not implemented: %v", *query)
+	// Look out for other hosts probing for the same names, and don't
+	// answer until the startup names are claimed
+	if p := s.probing.Load(); p != nil {
+		p.handleProbe(query)
+		if !s.claimed.Load() {
+			return nil
+		}
+	}
+
+	// RFC 6762, section 6.7.  Legacy Unicast Responses
+	//
+	// If the source UDP port in a received Multicast DNS query is not port
+	// 5353, this indicates that the querier originating the query is a
+	// simple resolver such as described in Section 5.1, "One-Shot Multicast
+	// DNS Queries", which does not fully implement all of Multicast DNS.
+	if addr, ok := from.(*net.UDPAddr); ok && addr.Port != mdnsPort {
+		return s.handleLegacyQuery(query, from)
 	}
 
-	var unicastAnswer, multicastAnswer []dns.RR
+	// RFC 6762, section 18.5.  TC (Truncated) Bit
+	//
+	// In query messages, if the TC bit is set, it means that additional
+	// Known-Answer records may be following shortly.  A responder SHOULD
+	// record this fact, and wait for those additional Known-Answer records,
+	// before deciding whether to respond.  If the TC bit is clear, it means
+	// that the querying host has no additional Known Answers.
+	query, ready := s.mergeTruncated(query, from)
+	if !ready {
+		return nil
+	}
+
+	var unicastAnswer, multicastAnswer, unicastExtra, multicastExtra []dns.RR
 
 	// Handle each question
 	for _, q := range query.Question {
 		mrecs, urecs := s.handleQuestion(q)
 		multicastAnswer = append(multicastAnswer, mrecs...)
 		unicastAnswer = append(unicastAnswer, urecs...)
-	}
-
-	// See section 18 of RFC 6762 for rules about DNS headers.
-	resp := func(unicast bool) *dns.Msg {
-		// 18.1: ID (Query Identifier)
-		// 0 for multicast response, query.Id for unicast response
-		id := uint16(0)
-		if unicast {
-			id = query.Id
-		}
-
-		var answer []dns.RR
-		if unicast {
-			answer = unicastAnswer
-		} else {
-			answer = multicastAnswer
-		}
-		if len(answer) == 0 {
-			return nil
-		}
 
-		return &dns.Msg{
-			MsgHdr: dns.MsgHdr{
-				Id: id,
-
-				// 18.2: QR (Query/Response) Bit - must be set to 1 in response.
-				Response: true,
-
-				// 18.3: OPCODE - must be zero in response (OpcodeQuery == 0)
-				Opcode: dns.OpcodeQuery,
-
-				// 18.4: AA (Authoritative Answer) Bit - must be set to 1
-				Authoritative: true,
-
-				// The following fields must all be set to 0:
-				// 18.5: TC (TRUNCATED) Bit
-				// 18.6: RD (Recursion Desired) Bit
-				// 18.7: RA (Recursion Available) Bit
-				// 18.8: Z (Zero) Bit
-				// 18.9: AD (Authentic Data) Bit
-				// 18.10: CD (Checking Disabled) Bit
-				// 18.11: RCODE (Response Code)
-			},
-			// 18.12 pertains to questions (handled by handleQuestion)
-			// 18.13 pertains to resource records (handled by handleQuestion)
-
-			// 18.14: Name Compression - responses should be compressed (though see
-			// caveats in the RFC), so set the Compress bit (part of the dns library
-			// API, not part of the DNS packet) to true.
-			Compress: true,
-
-			Answer: answer,
+		// The additional records go out the same way as the answers
+		if len(urecs) > 0 {
+			unicastExtra = append(unicastExtra, s.additionalRecords(q, urecs)...)
+		} else if len(mrecs) > 0 {
+			multicastExtra = append(multicastExtra, s.additionalRecords(q, mrecs)...)
 		}
 	}
 
+	// RFC 6762, section 7.1.  Known-Answer Suppression
+	//
+	// A Multicast DNS responder MUST NOT answer a Multicast DNS query if the
+	// answer it would give is already included in the Answer Section with an
+	// RR TTL at least half the correct value.
+	multicastAnswer = suppressKnownAnswers(multicastAnswer, query.Answer)
+	unicastAnswer = suppressKnownAnswers(unicastAnswer, query.Answer)
+	multicastExtra = trimAdditional(multicastExtra, multicastAnswer, query.Answer)
+	unicastExtra = trimAdditional(unicastExtra, unicastAnswer, query.Answer)
+
 	if s.config.LogEmptyResponses && len(multicastAnswer) == 0 && len(unicastAnswer) == 0 {
 		questions := make([]string, len(query.Question))
 		for i, q := range query.Question {
@@ -236,19 +385,242 @@ func (s *Server) handleQuery(query *dns.Msg, from net.Addr) error {
 		s.config.Logger.Printf("no responses for query with questions: %s", strings.Join(questions, ", "))
 	}
 
-	if mresp := resp(false); mresp != nil {
-		if err := s.sendResponse(mresp, from, false); err != nil {
+	if len(multicastAnswer) > 0 {
+		// Probes carry the proposed records in the authority section, and
+		// must be answered even if the records were just multicast
+		probe := len(query.Ns) > 0
+		if err := s.responses.schedule(multicastAnswer, multicastExtra, from, probe); err != nil {
 			return fmt.Errorf("mdns: error sending multicast response: %v", err)
 		}
 	}
-	if uresp := resp(true); uresp != nil {
-		if err := s.sendResponse(uresp, from, true); err != nil {
+	if len(unicastAnswer) > 0 {
+		// 18.1: ID (Query Identifier)
+		// 0 for multicast response, query.Id for unicast response
+		resp := newResponse(query.Id, unicastAnswer)
+		resp.Extra = unicastExtra
+		if err := s.sendResponse(resp, from, true); err != nil {
 			return fmt.Errorf("mdns: error sending unicast response: %v", err)
 		}
 	}
 	return nil
 }
 
+// handleLegacyQuery answers a query from a simple resolver with a
+// conventional unicast DNS response. "This unicast response MUST be a
+// conventional unicast response as would be generated by a conventional
+// Unicast DNS server; for example, it MUST repeat the query ID and the
+// question given in the query message."
+func (s *Server) handleLegacyQuery(query *dns.Msg, from net.Addr) error {
+	// "The resource record TTL given in a legacy unicast response SHOULD NOT
+	// be greater than ten seconds, even if the true TTL of the Multicast DNS
+	// resource record is higher." Simple resolvers don't know about the
+	// cache-flush bit either.
+	capTTL := func(records []dns.RR) []dns.RR {
+		capped := make([]dns.RR, len(records))
+		for i, rr := range records {
+			capped[i] = withoutCacheFlush(rr)
+			capped[i].Header().Ttl = min(rr.Header().Ttl, legacyTTL)
+		}
+		return capped
+	}
+
+	var answer, extra []dns.RR
+	for _, q := range query.Question {
+		mrecs, urecs := s.handleQuestion(q)
+		if len(mrecs) > 0 || len(urecs) > 0 {
+			recs := append(mrecs, urecs...)
+			answer = append(answer, capTTL(recs)...)
+			extra = append(extra, capTTL(s.additionalRecords(q, recs))...)
+		}
+	}
+	if len(answer) == 0 {
+		return nil
+	}
+
+	resp := newResponse(query.Id, answer)
+	resp.Question = query.Question
+	resp.Extra = trimAdditional(extra, answer, nil)
+	if err := s.sendResponse(resp, from, true); err != nil {
+		return fmt.Errorf("mdns: error sending legacy unicast response: %v", err)
+	}
+	return nil
+}
+
+// mergeTruncated combines a query with any earlier truncated query from the
+// same source. It returns false if the combined query is still waiting for
+// more known answers, in which case it will be handled once they arrive or
+// the wait times out.
+func (s *Server) mergeTruncated(query *dns.Msg, from net.Addr) (*dns.Msg, bool) {
+	key := from.String()
+
+	s.truncatedLock.Lock()
+	defer s.truncatedLock.Unlock()
+
+	pending, ok := s.truncated[key]
+	if !ok {
+		if !query.Truncated {
+			return query, true
+		}
+		pending = &truncatedQuery{query: query.Copy(), from: from}
+		pending.timer = time.AfterFunc(truncatedDelay+rand.N(truncatedJitter), func() {
+			s.flushTruncated(key, pending)
+		})
+		s.truncated[key] = pending
+		return nil, false
+	}
+
+	// RFC 6762, section 7.2.  Multipacket Known-Answer Suppression
+	//
+	// If the TC bit is set, the responder SHOULD delay its response by a
+	// random amount in the range 400-500 ms, to give the querier time to send
+	// additional Known-Answer packets.
+	pending.query.Question = append(pending.query.Question, query.Question...)
+	pending.query.Answer = append(pending.query.Answer, query.Answer...)
+	if query.Truncated {
+		pending.timer.Reset(truncatedDelay + rand.N(truncatedJitter))
+		return nil, false
+	}
+	pending.timer.Stop()
+	delete(s.truncated, key)
+	pending.query.Truncated = false
+	return pending.query, true
+}
+
+// flushTruncated answers a truncated query once no more known answers have
+// arrived for it in time
+func (s *Server) flushTruncated(key string, pending *truncatedQuery) {
+	s.truncatedLock.Lock()
+	if s.truncated[key] != pending {
+		// The rest of the known answers arrived and the query was handled
+		s.truncatedLock.Unlock()
+		return
+	}
+	delete(s.truncated, key)
+	s.truncatedLock.Unlock()
+
+	if s.shutdown.Load() == 1 {
+		return
+	}
+	pending.query.Truncated = false
+	if err := s.handleQuery(pending.query, pending.from); err != nil {
+		s.config.Logger.Printf("[ERR] mdns: Failed to handle query: %v", err)
+	}
+}
+
+// newResponse builds a response with the given ID and answers.
+//
+// See section 18 of RFC 6762 for rules about DNS headers.
+func newResponse(id uint16, answer []dns.RR) *dns.Msg {
+	return &dns.Msg{
+		MsgHdr: dns.MsgHdr{
+			// 18.1: ID (Query Identifier)
+			Id: id,
+
+			// 18.2: QR (Query/Response) Bit - must be set to 1 in response.
+			Response: true,
+
+			// 18.3: OPCODE - must be zero in response (OpcodeQuery == 0)
+			Opcode: dns.OpcodeQuery,
+
+			// 18.4: AA (Authoritative Answer) Bit - must be set to 1
+			Authoritative: true,
+
+			// The following fields must all be set to 0:
+			// 18.5: TC (TRUNCATED) Bit
+			// 18.6: RD (Recursion Desired) Bit
+			// 18.7: RA (Recursion Available) Bit
+			// 18.8: Z (Zero) Bit
+			// 18.9: AD (Authentic Data) Bit
+			// 18.10: CD (Checking Disabled) Bit
+			// 18.11: RCODE (Response Code)
+		},
+		// 18.12 pertains to questions (handled by handleQuestion)
+		// 18.13 pertains to resource records (handled by handleQuestion)
+
+		// 18.14: Name Compression - responses should be compressed (though see
+		// caveats in the RFC), so set the Compress bit (part of the dns library
+		// API, not part of the DNS packet) to true.
+		Compress: true,
+
+		Answer: answer,
+	}
+}
+
+// announce multicasts unsolicited responses carrying the zone's records, as
+// per section 8.3 of RFC 6762, until the server shuts down. Each change to
+// the records starts the announcements over, with the records as they are
+// at the time of each announcement, so that a later change is never undone
+// by an earlier announcement.
+func (s *Server) announce(zone PublishingZone) {
+	defer s.announcing.Done()
+
+	// "The Multicast DNS responder MUST send at least two unsolicited
+	// responses, one second apart."
+	remaining := announceCount
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-s.shutdownCh:
+			return
+		case <-s.announceCh:
+			remaining = announceCount
+			timer.Reset(0)
+			continue
+		case <-timer.C:
+		}
+
+		s.announceLock.Lock()
+		records := zone.PublishedRecords()
+		if s.shutdown.Load() == 0 {
+			for _, resp := range responsePackets(records, nil) {
+				if err := s.multicast(resp); err != nil {
+					s.config.Logger.Printf("[ERR] mdns: Failed to send announcement: %v", err)
+				}
+			}
+		}
+		s.announceLock.Unlock()
+
+		remaining--
+		if remaining > 0 {
+			timer.Reset(announceInterval)
+		}
+	}
+}
+
+// goodbye multicasts the given records with a TTL of zero, as per section
+// 10.1 of RFC 6762. The cache-flush bit is cleared so that caches only drop
+// the withdrawn records, and not the others with the same name and type.
+func (s *Server) goodbye(withdrawn []dns.RR) {
+	var records []dns.RR
+	for _, rr := range withdrawn {
+		rr = withoutCacheFlush(rr)
+		rr.Header().Ttl = 0
+		records = append(records, rr)
+	}
+	for _, resp := range responsePackets(records, nil) {
+		if err := s.multicast(resp); err != nil {
+			s.config.Logger.Printf("[ERR] mdns: Failed to send goodbye: %v", err)
+		}
+	}
+}
+
+// zoneChanged is called when the records of a WatchableZone change. It says
+// goodbye to the removed records and has the announcer announce the records
+// again, changed ones included, as per section 8.4 of RFC 6762.
+func (s *Server) zoneChanged(_, removed []dns.RR) {
+	s.announceLock.Lock()
+	defer s.announceLock.Unlock()
+	if s.shutdown.Load() == 1 {
+		return
+	}
+	s.goodbye(removed)
+	select {
+	case s.announceCh <- struct{}{}:
+	default:
+	}
+}
+
 // handleQuestion is used to handle an incoming question
 //
 // The response to a question may be transmitted over multicast, unicast, or
@@ -277,10 +649,97 @@ func (s *Server) handleQuestion(q dns.Question) (multicastRecs, unicastRecs []dn
 	return records, nil
 }
 
-// sendResponse is used to send a response packet
+// additionalRecords returns the records to add to the answers to a question.
+// Zones that don't implement AdditionalZone are asked for the records that
+// section 12 of RFC 6763 recommends: the SRV and TXT records of the instances
+// the PTR answers point to, and the addresses of the hosts the SRV records
+// point to.
+func (s *Server) additionalRecords(q dns.Question, answer []dns.RR) []dns.RR {
+	if zone, ok := s.config.Zone.(AdditionalZone); ok {
+		return zone.AdditionalRecords(q)
+	}
+
+	var extra []dns.RR
+	lookup := func(name string, qtypes ...uint16) {
+		for _, qtype := range qtypes {
+			extra = append(extra, s.config.Zone.Records(dns.Question{Name: name, Qtype: qtype, Qclass: dns.ClassINET})...)
+		}
+	}
+	for _, rr := range answer {
+		if ptr, ok := rr.(*dns.PTR); ok {
+			lookup(ptr.Ptr, dns.TypeSRV, dns.TypeTXT)
+		}
+	}
+	for _, rr := range slices.Concat(answer, extra) {
+		if srv, ok := rr.(*dns.SRV); ok {
+			lookup(srv.Target, dns.TypeA, dns.TypeAAAA)
+		}
+	}
+	return extra
+}
+
+// trimAdditional drops the additional records that repeat an answer, a known
+// answer or an earlier additional record
+func trimAdditional(extra, answer, known []dns.RR) []dns.RR {
+	var trimmed []dns.RR
+	for _, rr := range suppressKnownAnswers(extra, known) {
+		duplicate := func(old dns.RR) bool { return isDuplicate(old, rr) }
+		if !slices.ContainsFunc(answer, duplicate) && !slices.ContainsFunc(trimmed, duplicate) {
+			trimmed = append(trimmed, rr)
+		}
+	}
+	return trimmed
+}
+
+// suppressKnownAnswers returns the records that do not appear in the known
+// answers with at least half of their TTL remaining
+func suppressKnownAnswers(records, known []dns.RR) []dns.RR {
+	if len(known) == 0 {
+		return records
+	}
+	var answers []dns.RR
+	for _, rr := range records {
+		if !isKnownAnswer(rr, known) {
+			answers = append(answers, rr)
+		}
+	}
+	return answers
+}
+
+// isKnownAnswer checks if a record appears in the known answers with at
+// least half of its TTL remaining
+func isKnownAnswer(rr dns.RR, known []dns.RR) bool {
+	for _, k := range known {
+		if 2*uint64(k.Header().Ttl) >= uint64(rr.Header().Ttl) && isDuplicate(k, rr) {
+			return true
+		}
+	}
+	return false
+}
+
+// multicast is used to send a message to the mDNS multicast group
+func (s *Server) multicast(msg *dns.Msg) error {
+	buf, err := msg.Pack()
+	if err != nil {
+		return err
+	}
+	if s.ipv4List != nil {
+		if _, err := s.ipv4List.WriteToUDP(buf, ipv4Addr); err != nil {
+			return err
+		}
+	}
+	if s.ipv6List != nil {
+		if _, err := s.ipv6List.WriteToUDP(buf, ipv6Addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendResponse is used to send a response packet. Unicast responses go back
+// to the querier, and multicast responses to the group the query arrived on
+// so that every cache on the link sees them.
 func (s *Server) sendResponse(resp *dns.Msg, from net.Addr, unicast bool) error {
-	// TODO(reddaly): Respect the unicast argument, and allow sending responses
-	// over multicast.
 	buf, err := resp.Pack()
 	if err != nil {
 		return err
@@ -289,9 +748,15 @@ func (s *Server) sendResponse(resp *dns.Msg, from net.Addr, unicast bool) error
 	// Determine the socket to send from
 	addr := from.(*net.UDPAddr)
 	if addr.IP.To4() != nil {
+		if !unicast {
+			addr = ipv4Addr
+		}
 		_, err = s.ipv4List.WriteToUDP(buf, addr)
 		return err
 	} else {
+		if !unicast {
+			addr = ipv6Addr
+		}
 		_, err = s.ipv6List.WriteToUDP(buf, addr)
 		return err
 	}
@@ -5,8 +5,13 @@ package mdns
 
 import (
 	"fmt"
+	"net"
+	"slices"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/miekg/dns"
 )
 
 func TestServer_StartStop(t *testing.T) {
@@ -73,3 +78,717 @@ func TestServer_Lookup(t *testing.T) {
 		t.Fatalf("err: %v", err)
 	}
 }
+
+func TestServer_LookupSubtype(t *testing.T) {
+	s := makeServiceWithServiceName(t, "_subtype._tcp")
+	s.Subtypes = []string{"_leader"}
+	serv, err := NewServer(&Config{Zone: s})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer func() {
+		if err := serv.Shutdown(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}()
+
+	for _, test := range []struct {
+		subtype string
+		found   bool
+	}{
+		{"_leader", true},
+		{"_follower", false},
+	} {
+		entries := make(chan *ServiceEntry, 1)
+		err := Query(&QueryParam{
+			Service:     "_subtype._tcp",
+			Subtype:     test.subtype,
+			Domain:      "local",
+			Timeout:     50 * time.Millisecond,
+			Entries:     entries,
+			DisableIPv6: true,
+		})
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if found := len(entries) == 1; found != test.found {
+			t.Fatalf("subtype %s: got entry %v, want %v", test.subtype, found, test.found)
+		}
+	}
+}
+
+func TestServer_WrappedZone(t *testing.T) {
+	// A zone that only forwards Records still gets the instance records
+	// into the additional section
+	zone := struct{ Zone }{makeServiceWithServiceName(t, "_wrapped._tcp")}
+	q := dns.Question{Name: "_wrapped._tcp.local.", Qtype: dns.TypePTR}
+	extra := (&Server{config: &Config{Zone: zone}}).additionalRecords(q, zone.Records(q))
+	types := recordTypes(extra)
+	for _, rrtype := range []uint16{dns.TypeSRV, dns.TypeTXT, dns.TypeA, dns.TypeAAAA} {
+		if !slices.Contains(types, rrtype) {
+			t.Fatalf("expected a %s record in the additional records: %v", dns.TypeToString[rrtype], extra)
+		}
+	}
+
+	serv, err := NewServer(&Config{Zone: zone})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer func() {
+		if err := serv.Shutdown(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}()
+
+	entries := make(chan *ServiceEntry, 1)
+	err = Query(&QueryParam{
+		Service:     "_wrapped._tcp",
+		Domain:      "local",
+		Timeout:     200 * time.Millisecond,
+		Entries:     entries,
+		DisableIPv6: true,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected an entry for the wrapped zone")
+	}
+}
+
+func TestServer_KnownAnswerSuppression(t *testing.T) {
+	s := makeService(t)
+	records := s.Records(dns.Question{Name: "_http._tcp.local.", Qtype: dns.TypePTR})
+
+	known := []dns.RR{makePTR("_http._tcp.local.", "hostname._http._tcp.local.", defaultTTL/2)}
+	answers := suppressKnownAnswers(records, known)
+	if len(answers) != len(records)-1 {
+		t.Fatalf("expected the PTR record to be suppressed: %v", answers)
+	}
+	for _, rr := range answers {
+		if _, ok := rr.(*dns.PTR); ok {
+			t.Fatalf("expected the PTR record to be suppressed: %v", answers)
+		}
+	}
+
+	// Known answers with less than half the TTL remaining don't count
+	known[0].Header().Ttl = defaultTTL/2 - 1
+	if answers := suppressKnownAnswers(records, known); len(answers) != len(records) {
+		t.Fatalf("expected no records to be suppressed: %v", answers)
+	}
+
+	// Neither do records with different data
+	known = []dns.RR{makePTR("_http._tcp.local.", "other._http._tcp.local.", defaultTTL)}
+	if answers := suppressKnownAnswers(records, known); len(answers) != len(records) {
+		t.Fatalf("expected no records to be suppressed: %v", answers)
+	}
+}
+
+func TestServer_QueryKnownAnswers(t *testing.T) {
+	conn, err := net.ListenMulticastUDP("udp4", nil, ipv4Addr)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer conn.Close()
+
+	ip := net.IPv4(192, 168, 0, 42)
+	r, err := NewServiceRegistry(
+		makeRegistryService(t, "known", "_known._tcp", ip),
+		makeRegistryService(t, "unknown", "_known._tcp", ip),
+	)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	// Hide PublishedRecords so the server doesn't announce the zone
+	serv, err := NewServer(&Config{Zone: struct{ Zone }{r}})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer func() {
+		if err := serv.Shutdown(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}()
+
+	// The PTR record already held in the cache is sent as a known answer,
+	// and left out of the server's response. Earlier runs of the test leave
+	// the other one in the cache, so start from an empty one.
+	sharedCache.expire(time.Now().Add(time.Hour))
+	sharedCache.add(makePTR("_known._tcp.local.", "known._known._tcp.local.", defaultTTL), nil, time.Now())
+	entries := make(chan *ServiceEntry, 4)
+	err = Query(&QueryParam{
+		Service:     "_known._tcp",
+		Domain:      "local",
+		Timeout:     200 * time.Millisecond,
+		Entries:     entries,
+		DisableIPv6: true,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	var ptrs []string
+	deadline := time.Now().Add(100 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		resp := readResponse(t, conn, time.Until(deadline))
+		if resp == nil || !resp.Response {
+			continue
+		}
+		for _, rr := range resp.Answer {
+			if ptr, ok := rr.(*dns.PTR); ok && ptr.Hdr.Name == "_known._tcp.local." {
+				ptrs = append(ptrs, ptr.Ptr)
+			}
+		}
+	}
+	if !slices.Equal(ptrs, []string{"unknown._known._tcp.local."}) {
+		t.Fatalf("expected only the unknown instance to be answered, got: %v", ptrs)
+	}
+}
+
+// readResponse waits for a response sent to conn
+func readResponse(t *testing.T, conn *net.UDPConn, timeout time.Duration) *dns.Msg {
+	t.Helper()
+	buf := make([]byte, 65536)
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil
+	}
+	msg := new(dns.Msg)
+	if err := msg.Unpack(buf[:n]); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return msg
+}
+
+// readAnswer waits for a response sent to conn with an answer under name,
+// skipping any other traffic on the group
+func readAnswer(t *testing.T, conn *net.UDPConn, name string, timeout time.Duration) *dns.Msg {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		resp := readResponse(t, conn, time.Until(deadline))
+		if resp == nil || !resp.Response {
+			continue
+		}
+		for _, rr := range resp.Answer {
+			if strings.HasSuffix(strings.ToLower(rr.Header().Name), name) {
+				return resp
+			}
+		}
+	}
+	return nil
+}
+
+func TestServer_MulticastResponse(t *testing.T) {
+	conn, err := net.ListenMulticastUDP("udp4", nil, ipv4Addr)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer conn.Close()
+
+	// Hide PublishedRecords so the server doesn't announce the zone
+	serv, err := NewServer(&Config{Zone: struct{ Zone }{makeServiceWithServiceName(t, "_multicast._tcp")}})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer func() {
+		if err := serv.Shutdown(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}()
+
+	q := new(dns.Msg)
+	q.SetQuestion("_multicast._tcp.local.", dns.TypePTR)
+	if err := serv.handleQuery(q, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: mdnsPort}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp := readAnswer(t, conn, "_multicast._tcp.local.", time.Second)
+	if resp == nil {
+		t.Fatalf("expected a multicast response")
+	}
+	if resp.Id != 0 || len(resp.Question) != 0 {
+		t.Fatalf("bad response: %v", resp)
+	}
+}
+
+func TestServer_LegacyQuery(t *testing.T) {
+	mconn, err := net.ListenMulticastUDP("udp4", nil, ipv4Addr)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer mconn.Close()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer conn.Close()
+
+	serv, err := NewServer(&Config{Zone: struct{ Zone }{makeServiceWithServiceName(t, "_legacy._tcp")}})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer func() {
+		if err := serv.Shutdown(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}()
+
+	q := new(dns.Msg)
+	q.SetQuestion("_legacy._tcp.local.", dns.TypePTR)
+	if err := serv.handleQuery(q, conn.LocalAddr()); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp := readResponse(t, conn, time.Second)
+	if resp == nil {
+		t.Fatalf("expected a unicast response")
+	}
+	if resp.Id != q.Id || len(resp.Question) != 1 || resp.Question[0] != q.Question[0] {
+		t.Fatalf("expected the query ID and question to be repeated: %v", resp)
+	}
+	if len(resp.Answer) == 0 {
+		t.Fatalf("expected answers: %v", resp)
+	}
+	for _, rr := range append(resp.Answer, resp.Extra...) {
+		if rr.Header().Ttl > legacyTTL {
+			t.Fatalf("expected the TTL to be capped: %v", rr)
+		}
+		if rr.Header().Class != dns.ClassINET {
+			t.Fatalf("expected the cache-flush bit to be cleared: %v", rr)
+		}
+	}
+	if resp := readAnswer(t, mconn, "_legacy._tcp.local.", 100*time.Millisecond); resp != nil {
+		t.Fatalf("expected no multicast response, got: %v", resp)
+	}
+}
+
+func TestServer_AdditionalRecords(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer conn.Close()
+
+	serv, err := NewServer(&Config{Zone: struct{ AdditionalZone }{makeServiceWithServiceName(t, "_additional._tcp")}})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer func() {
+		if err := serv.Shutdown(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}()
+
+	q := new(dns.Msg)
+	q.SetQuestion("_additional._tcp.local.", dns.TypePTR)
+	q.Question = append(q.Question, dns.Question{Name: "hostname._additional._tcp.local.", Qtype: dns.TypeSRV, Qclass: dns.ClassINET})
+	if err := serv.handleQuery(q, conn.LocalAddr()); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp := readResponse(t, conn, time.Second)
+	if resp == nil {
+		t.Fatalf("expected a response")
+	}
+	if len(resp.Answer) != 2 {
+		t.Fatalf("expected the PTR and SRV records as answers: %v", resp)
+	}
+	// The SRV record is already an answer, and the address and NSEC records
+	// are only added once
+	if len(resp.Extra) != 5 {
+		t.Fatalf("expected the TXT, A, AAAA and NSEC records as additional records: %v", resp)
+	}
+	for _, rr := range resp.Extra {
+		if _, ok := rr.(*dns.SRV); ok {
+			t.Fatalf("expected the SRV record not to be repeated: %v", resp)
+		}
+	}
+}
+
+func TestServer_TruncatedQuery(t *testing.T) {
+	conn, err := net.ListenMulticastUDP("udp4", nil, ipv4Addr)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer conn.Close()
+
+	serv, err := NewServer(&Config{Zone: struct{ Zone }{makeServiceWithServiceName(t, "_truncated._tcp")}})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer func() {
+		if err := serv.Shutdown(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}()
+	from := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: mdnsPort}
+
+	q := new(dns.Msg)
+	q.SetQuestion("_truncated._tcp.local.", dns.TypePTR)
+	q.Question = append(q.Question, dns.Question{Name: "hostname._truncated._tcp.local.", Qtype: dns.TypeSRV, Qclass: dns.ClassINET})
+	q.Truncated = true
+	if err := serv.handleQuery(q, from); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp := readAnswer(t, conn, "_truncated._tcp.local.", 100*time.Millisecond); resp != nil {
+		t.Fatalf("expected the server to wait for known answers, got: %v", resp)
+	}
+
+	// The last packet of known answers completes the query
+	known := &dns.Msg{Answer: []dns.RR{makePTR("_truncated._tcp.local.", "hostname._truncated._tcp.local.", defaultTTL)}}
+	if err := serv.handleQuery(known, from); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp := readAnswer(t, conn, "_truncated._tcp.local.", 100*time.Millisecond)
+	if resp == nil {
+		t.Fatalf("expected a response")
+	}
+	for _, rr := range resp.Answer {
+		if _, ok := rr.(*dns.PTR); ok {
+			t.Fatalf("expected the known PTR record to be suppressed: %v", resp)
+		}
+	}
+
+	// Without any more known answers the query is answered after a delay
+	start := time.Now()
+	if err := serv.handleQuery(q, from); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if resp := readAnswer(t, conn, "_truncated._tcp.local.", time.Second); resp == nil {
+		t.Fatalf("expected a response")
+	}
+	if elapsed := time.Since(start); elapsed < truncatedDelay {
+		t.Fatalf("expected the response to be delayed, got it after %v", elapsed)
+	}
+}
+
+func TestServer_Announce(t *testing.T) {
+	conn, err := net.ListenMulticastUDP("udp4", nil, ipv4Addr)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer conn.Close()
+
+	serv, err := NewServer(&Config{Zone: makeServiceWithServiceName(t, "_announce._tcp")})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer func() {
+		if err := serv.Shutdown(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}()
+
+	var announcements []time.Time
+	deadline := time.Now().Add(2 * time.Second)
+	for len(announcements) < announceCount && time.Now().Before(deadline) {
+		resp := readResponse(t, conn, time.Until(deadline))
+		if resp == nil || !resp.Response {
+			continue
+		}
+		for _, rr := range resp.Answer {
+			if ptr, ok := rr.(*dns.PTR); ok && ptr.Ptr == "hostname._announce._tcp.local." {
+				announcements = append(announcements, time.Now())
+				if len(resp.Answer) != len(serv.config.Zone.(PublishingZone).PublishedRecords()) {
+					t.Fatalf("expected every record to be announced: %v", resp)
+				}
+				break
+			}
+		}
+	}
+	if len(announcements) != announceCount {
+		t.Fatalf("got %d announcements, want %d", len(announcements), announceCount)
+	}
+	if gap := announcements[1].Sub(announcements[0]); gap < announceInterval-100*time.Millisecond {
+		t.Fatalf("announcements sent %v apart, want %v", gap, announceInterval)
+	}
+}
+
+func TestServer_AnnounceLargeZone(t *testing.T) {
+	conn, err := net.ListenMulticastUDP("udp4", nil, ipv4Addr)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer conn.Close()
+
+	ip := net.IPv4(192, 168, 0, 42)
+	var services []*MDNSService
+	for i := 0; i < 12; i++ {
+		services = append(services, makeRegistryService(t, fmt.Sprintf("service %d with a long instance name", i), "_large._tcp", ip))
+	}
+	r, err := NewServiceRegistry(services...)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	serv, err := NewServer(&Config{Zone: r})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer func() {
+		if err := serv.Shutdown(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}()
+
+	// The records are spread over several packets within the MTU
+	announced := make(map[string]bool)
+	buf := make([]byte, 65536)
+	deadline := time.Now().Add(time.Second)
+	for len(announced) < len(services) && time.Now().Before(deadline) {
+		if err := conn.SetReadDeadline(deadline); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		n, err := conn.Read(buf)
+		if err != nil {
+			break
+		}
+		resp := new(dns.Msg)
+		if err := resp.Unpack(buf[:n]); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		for _, rr := range resp.Answer {
+			if !strings.HasSuffix(rr.Header().Name, "_large._tcp.local.") {
+				continue
+			}
+			if n > maxPacketSize {
+				t.Fatalf("announcement of %d bytes is larger than %d", n, maxPacketSize)
+			}
+			if ptr, ok := rr.(*dns.PTR); ok && ptr.Hdr.Name == "_large._tcp.local." {
+				announced[ptr.Ptr] = true
+			}
+		}
+	}
+	if len(announced) != len(services) {
+		t.Fatalf("got %d instances announced, want %d", len(announced), len(services))
+	}
+}
+
+// readGoodbye waits for a response withdrawing every record of the instance
+func readGoodbye(t *testing.T, conn *net.UDPConn, instance string, timeout time.Duration) *dns.Msg {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		resp := readResponse(t, conn, time.Until(deadline))
+		if resp == nil || !resp.Response || len(resp.Answer) == 0 {
+			continue
+		}
+		goodbye := true
+		for _, rr := range resp.Answer {
+			if rr.Header().Ttl != 0 {
+				goodbye = false
+			}
+		}
+		for _, rr := range resp.Answer {
+			if ptr, ok := rr.(*dns.PTR); ok && ptr.Ptr == instance && goodbye {
+				return resp
+			}
+		}
+	}
+	return nil
+}
+
+func TestServer_Goodbye(t *testing.T) {
+	conn, err := net.ListenMulticastUDP("udp4", nil, ipv4Addr)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer conn.Close()
+
+	s := makeServiceWithServiceName(t, "_goodbye._tcp")
+	serv, err := NewServer(&Config{Zone: s})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := serv.Shutdown(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	resp := readGoodbye(t, conn, "hostname._goodbye._tcp.local.", time.Second)
+	if resp == nil {
+		t.Fatalf("expected a goodbye")
+	}
+	if got, want := len(resp.Answer), len(s.PublishedRecords()); got != want {
+		t.Fatalf("got %d records in goodbye, want %d: %v", got, want, resp)
+	}
+
+	// The remaining announcement must not follow the goodbye
+	deadline := time.Now().Add(announceInterval + 200*time.Millisecond)
+	for time.Now().Before(deadline) {
+		resp := readResponse(t, conn, time.Until(deadline))
+		if resp == nil || !resp.Response {
+			continue
+		}
+		for _, rr := range resp.Answer {
+			if rr.Header().Name == s.instanceAddr && rr.Header().Ttl > 0 {
+				t.Fatalf("expected no announcement after the goodbye, got: %v", resp)
+			}
+		}
+	}
+}
+
+func TestServer_GoodbyeLargeZone(t *testing.T) {
+	conn, err := net.ListenMulticastUDP("udp4", nil, ipv4Addr)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer conn.Close()
+
+	ip := net.IPv4(192, 168, 0, 42)
+	var services []*MDNSService
+	for i := 0; i < 12; i++ {
+		services = append(services, makeRegistryService(t, fmt.Sprintf("service %d with a long instance name", i), "_farewell._tcp", ip))
+	}
+	r, err := NewServiceRegistry(services...)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	serv, err := NewServer(&Config{Zone: r})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := serv.Shutdown(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Every record is withdrawn, in packets within the MTU
+	withdrawn := 0
+	buf := make([]byte, 65536)
+	deadline := time.Now().Add(time.Second)
+	for withdrawn < len(r.PublishedRecords()) && time.Now().Before(deadline) {
+		if err := conn.SetReadDeadline(deadline); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		n, err := conn.Read(buf)
+		if err != nil {
+			break
+		}
+		resp := new(dns.Msg)
+		if err := resp.Unpack(buf[:n]); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if !resp.Response || len(resp.Answer) == 0 || resp.Answer[0].Header().Ttl != 0 {
+			continue
+		}
+		if n > maxPacketSize {
+			t.Fatalf("goodbye of %d bytes is larger than %d", n, maxPacketSize)
+		}
+		withdrawn += len(resp.Answer)
+	}
+	if want := len(r.PublishedRecords()); withdrawn != want {
+		t.Fatalf("got %d records withdrawn, want %d", withdrawn, want)
+	}
+}
+
+func TestServer_DisableGoodbyes(t *testing.T) {
+	conn, err := net.ListenMulticastUDP("udp4", nil, ipv4Addr)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer conn.Close()
+
+	serv, err := NewServer(&Config{
+		Zone:            makeServiceWithServiceName(t, "_crash._tcp"),
+		DisableGoodbyes: true,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := serv.Shutdown(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if resp := readGoodbye(t, conn, "hostname._crash._tcp.local.", 200*time.Millisecond); resp != nil {
+		t.Fatalf("expected no goodbye, got: %v", resp)
+	}
+}
+
+func TestServer_Update(t *testing.T) {
+	conn, err := net.ListenMulticastUDP("udp4", nil, ipv4Addr)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer conn.Close()
+
+	s := makeServiceWithServiceName(t, "_update._tcp")
+	serv, err := NewServer(&Config{Zone: s})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer func() {
+		if err := serv.Shutdown(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}()
+
+	if err := s.SetIPs([]net.IP{net.IP([]byte{192, 168, 0, 42})}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	s.SetTXT([]string{"Updated web server"})
+
+	var goodbye, announced bool
+	deadline := time.Now().Add(time.Second)
+	for !(goodbye && announced) && time.Now().Before(deadline) {
+		resp := readResponse(t, conn, time.Until(deadline))
+		if resp == nil || !resp.Response {
+			continue
+		}
+		for _, rr := range resp.Answer {
+			switch rr := rr.(type) {
+			case *dns.AAAA:
+				goodbye = goodbye || rr.Hdr.Ttl == 0
+			case *dns.TXT:
+				announced = announced || (rr.Hdr.Ttl > 0 && rr.Txt[0] == "Updated web server")
+			}
+		}
+	}
+	if !goodbye {
+		t.Fatalf("expected a goodbye for the removed address")
+	}
+	if !announced {
+		t.Fatalf("expected the new TXT record to be announced")
+	}
+}
+
+func TestServer_RapidUpdates(t *testing.T) {
+	conn, err := net.ListenMulticastUDP("udp4", nil, ipv4Addr)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer conn.Close()
+
+	s := makeServiceWithServiceName(t, "_rapid._tcp")
+	serv, err := NewServer(&Config{Zone: s})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer func() {
+		if err := serv.Shutdown(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}()
+
+	s.SetTXT([]string{"v1"})
+	s.SetTXT([]string{"v2"})
+
+	// Once v2 is announced, v1 must not be announced again
+	var latest string
+	deadline := time.Now().Add(announceCount*announceInterval + 500*time.Millisecond)
+	for time.Now().Before(deadline) {
+		resp := readResponse(t, conn, time.Until(deadline))
+		if resp == nil || !resp.Response {
+			continue
+		}
+		for _, rr := range resp.Answer {
+			if txt, ok := rr.(*dns.TXT); ok && txt.Hdr.Name == s.instanceAddr && txt.Hdr.Ttl > 0 {
+				if latest == "v2" && txt.Txt[0] != "v2" {
+					t.Fatalf("announced %s after v2", txt.Txt[0])
+				}
+				latest = txt.Txt[0]
+			}
+		}
+	}
+	if latest != "v2" {
+		t.Fatalf("expected v2 to be announced, got %q", latest)
+	}
+}
@@ -7,7 +7,10 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/miekg/dns"
 )
@@ -24,7 +27,100 @@ type Zone interface {
 	Records(q dns.Question) []dns.RR
 }
 
-// MDNSService is used to export a named service by implementing a Zone
+// PublishingZone is implemented by zones that can list every record they
+// publish. The server uses the list to probe for the zone's unique names
+// before it starts answering; zones that don't implement it are answered for
+// straight away.
+type PublishingZone interface {
+	Zone
+
+	// PublishedRecords returns every record the zone publishes.
+	PublishedRecords() []dns.RR
+}
+
+// RenamingZone is implemented by zones that can pick a new name when the
+// server finds that another host already uses one of their names.
+type RenamingZone interface {
+	PublishingZone
+
+	// Rename replaces one of the zone's names, as it appears in its records,
+	// and returns the new name.
+	Rename(name string) (string, error)
+}
+
+// WatchableZone is implemented by zones whose records can change while the
+// server is running. The server announces the changed records again and
+// sends goodbyes for the removed ones.
+type WatchableZone interface {
+	PublishingZone
+
+	// Watch registers fn to be called after the zone's records change, with
+	// the records that were added or changed and the records that are no
+	// longer published. The returned function stops the notifications.
+	Watch(fn func(changed, removed []dns.RR)) (stop func())
+}
+
+// ClaimingZone is implemented by zones that can add unique names while the
+// server is running, such as a ServiceRegistry. The server probes for the
+// new names, as it does for the zone's names when it starts, before the zone
+// publishes them.
+type ClaimingZone interface {
+	WatchableZone
+
+	// SetClaimer registers fn to claim the unique records of zone before
+	// they are published, or unregisters it if fn is nil. fn renames zone
+	// if another host uses one of its names and zone implements
+	// RenamingZone, and fails if the names can't be claimed.
+	SetClaimer(fn func(zone PublishingZone) error)
+}
+
+// AdditionalZone is implemented by zones that can tell the records that
+// answer a question apart from the records a querier is likely to need next,
+// such as the SRV, TXT and address records of a service instance. The server
+// puts the latter in the additional section of its responses, as per section
+// 12 of RFC 6763.
+type AdditionalZone interface {
+	Zone
+
+	// AdditionalRecords returns the records to add to the answers to a DNS
+	// question.
+	AdditionalRecords(q dns.Question) []dns.RR
+}
+
+// cacheFlush is the top bit of a record's class. Zones set it on unique
+// records, meaning that no other host should publish a record with the same
+// name and type, and it tells caches to flush any other records with that
+// name and type, as per section 10.2 of RFC 6762.
+const cacheFlush = 1 << 15
+
+// isUnique checks if a record is unique
+func isUnique(rr dns.RR) bool {
+	return rr.Header().Class&cacheFlush != 0
+}
+
+// withoutCacheFlush returns a copy of a record without the cache-flush bit.
+// "The cache-flush bit is only set in records in the Resource Record
+// Sections of Multicast DNS responses sent to UDP port 5353."
+func withoutCacheFlush(rr dns.RR) dns.RR {
+	rr = dns.Copy(rr)
+	rr.Header().Class &^= cacheFlush
+	return rr
+}
+
+// isDuplicate checks if two records are the same, ignoring their TTLs and
+// the cache-flush bit
+func isDuplicate(a, b dns.RR) bool {
+	if a.Header().Class != b.Header().Class && a.Header().Class&^cacheFlush == b.Header().Class&^cacheFlush {
+		return dns.IsDuplicate(withoutCacheFlush(a), withoutCacheFlush(b))
+	}
+	return dns.IsDuplicate(a, b)
+}
+
+// MDNSService is used to export a named service by implementing a Zone.
+//
+// Once the service is published, use SetPort, SetIPs, SetTXT and SetSubtypes
+// to change it rather than writing to the fields directly, and don't read the
+// fields while another goroutine may be calling the setters.
 type MDNSService struct {
 	Instance string   // Instance name (e.g. "hostService name")
 	Service  string   // Service name (e.g. "_http._tcp.")
@@ -33,10 +129,15 @@ type MDNSService struct {
 	Port     int      // Service Port
 	IPs      []net.IP // IP addresses for the service's host
 	TXT      []string // Service TXT records
+	Subtypes []string // Service subtypes (e.g. "_printer")
 
 	serviceAddr  string // Fully qualified service address
 	instanceAddr string // Fully qualified instance address
 	enumAddr     string // _services._dns-sd._udp.<domain>
+
+	mu          sync.RWMutex
+	watchers    map[int]func(changed, removed []dns.RR)
+	nextWatcher int
 }
 
 // validateFQDN returns an error if the passed string is not a fully qualified
@@ -58,11 +159,8 @@ func validateFQDN(s string) error {
 // If domain, hostName, or ips is set to the zero value, then a default value
 // will be inferred from the operating system.
 //
-// TODO(reddaly): This interface may need to change to account for "unique
-// record" conflict rules of the mDNS protocol.  Upon startup, the server should
-// check to ensure that the instance name does not conflict with other instance
-// names, and, if required, select a new name.  There may also be conflicting
-// hostName A/AAAA records.
+// Upon startup, the server checks that the instance name and hostName are not
+// used by other hosts. If they are, the service is renamed: see Rename.
 func NewMDNSService(instance, service, domain, hostName string, port int, ips []net.IP, txt []string) (*MDNSService, error) {
 	// Sanity check inputs
 	if instance == "" {
@@ -111,10 +209,8 @@ func NewMDNSService(instance, service, domain, hostName string, port int, ips []
 			}
 		}
 	}
-	for _, ip := range ips {
-		if ip.To4() == nil && ip.To16() == nil {
-			return nil, fmt.Errorf("invalid IP address in IPs list: %v", ip)
-		}
+	if err := validateIPs(ips); err != nil {
+		return nil, err
 	}
 
 	return &MDNSService{
@@ -131,30 +227,310 @@ func NewMDNSService(instance, service, domain, hostName string, port int, ips []
 	}, nil
 }
 
+// validateIPs returns an error if any of the addresses is not a valid IPv4 or
+// IPv6 address
+func validateIPs(ips []net.IP) error {
+	for _, ip := range ips {
+		if ip.To4() == nil && ip.To16() == nil {
+			return fmt.Errorf("invalid IP address in IPs list: %v", ip)
+		}
+	}
+	return nil
+}
+
+// Rename picks a new instance name or host name for the service, depending on
+// which one name is. Instances are renamed from "name" to "name (2)", and
+// host names from "host.local." to "host-2.local.", counting up on further
+// conflicts.
+func (m *MDNSService) Rename(name string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch name {
+	case m.instanceAddr:
+		m.Instance = nextInstanceName(m.Instance)
+		m.instanceAddr = fmt.Sprintf("%s.%s.%s.", m.Instance, trimDot(m.Service), trimDot(m.Domain))
+		return m.instanceAddr, nil
+	case m.HostName:
+		m.HostName = nextHostName(m.HostName)
+		return m.HostName, nil
+	default:
+		return "", fmt.Errorf("%s is not a name of this service", name)
+	}
+}
+
+// names returns the instance and host names of the service
+func (m *MDNSService) names() (instance, host string) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.instanceAddr, m.HostName
+}
+
+// nextInstanceName returns the instance name to try after a conflict, as
+// per section 9 of RFC 6762: "name" becomes "name (2)", then "name (3)"
+func nextInstanceName(instance string) string {
+	if i := strings.LastIndex(instance, " ("); i >= 0 && strings.HasSuffix(instance, ")") {
+		if n, err := strconv.Atoi(instance[i+2 : len(instance)-1]); err == nil && n >= 2 {
+			return fmt.Sprintf("%s (%d)", instance[:i], n+1)
+		}
+	}
+	return instance + " (2)"
+}
+
+// nextHostName returns the host name to try after a conflict: the first
+// label "host" becomes "host-2", then "host-3"
+func nextHostName(hostName string) string {
+	label, rest, _ := strings.Cut(hostName, ".")
+	if i := strings.LastIndex(label, "-"); i >= 0 {
+		if n, err := strconv.Atoi(label[i+1:]); err == nil && n >= 2 {
+			return fmt.Sprintf("%s-%d.%s", label[:i], n+1, rest)
+		}
+	}
+	return fmt.Sprintf("%s-2.%s", label, rest)
+}
+
 // trimDot is used to trim the dots from the start or end of a string
 func trimDot(s string) string {
 	return strings.Trim(s, ".")
 }
 
+// SetPort changes the port of the service.
+func (m *MDNSService) SetPort(port int) error {
+	if port == 0 {
+		return fmt.Errorf("missing service port")
+	}
+	m.update(func() {
+		m.Port = port
+	})
+	return nil
+}
+
+// SetIPs changes the IP addresses of the service's host.
+func (m *MDNSService) SetIPs(ips []net.IP) error {
+	if err := validateIPs(ips); err != nil {
+		return err
+	}
+	m.update(func() {
+		m.IPs = ips
+	})
+	return nil
+}
+
+// SetTXT changes the TXT records of the service.
+func (m *MDNSService) SetTXT(txt []string) {
+	m.update(func() {
+		m.TXT = txt
+	})
+}
+
+// SetSubtypes changes the subtypes of the service.
+func (m *MDNSService) SetSubtypes(subtypes []string) {
+	m.update(func() {
+		m.Subtypes = subtypes
+	})
+}
+
+// update applies a change to the service and tells the watchers which
+// records changed
+func (m *MDNSService) update(change func()) {
+	m.mu.Lock()
+	old := m.publishedRecords()
+	change()
+	changed, removed := diffRecords(old, m.publishedRecords())
+	watchers := make([]func(changed, removed []dns.RR), 0, len(m.watchers))
+	for _, fn := range m.watchers {
+		watchers = append(watchers, fn)
+	}
+	m.mu.Unlock()
+
+	if len(changed) == 0 && len(removed) == 0 {
+		return
+	}
+	for _, fn := range watchers {
+		fn(changed, removed)
+	}
+}
+
+// Watch registers fn to be called after the service changes.
+func (m *MDNSService) Watch(fn func(changed, removed []dns.RR)) (stop func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.watchers == nil {
+		m.watchers = make(map[int]func(changed, removed []dns.RR))
+	}
+	id := m.nextWatcher
+	m.nextWatcher++
+	m.watchers[id] = fn
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		delete(m.watchers, id)
+	}
+}
+
+// diffRecords returns the records in next that are not in prev, and the
+// records in prev that are not in next
+func diffRecords(prev, next []dns.RR) (added, removed []dns.RR) {
+	for _, rr := range next {
+		if !slices.ContainsFunc(prev, func(old dns.RR) bool { return isDuplicate(old, rr) }) {
+			added = append(added, rr)
+		}
+	}
+	for _, rr := range prev {
+		if !slices.ContainsFunc(next, func(cur dns.RR) bool { return isDuplicate(cur, rr) }) {
+			removed = append(removed, rr)
+		}
+	}
+	return added, removed
+}
+
 // Records returns DNS records in response to a DNS question.
 func (m *MDNSService) Records(q dns.Question) []dns.RR {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	switch q.Name {
 	case m.enumAddr:
 		return m.serviceEnum(q)
 	case m.serviceAddr:
 		return m.serviceRecords(q)
 	case m.instanceAddr:
-		return m.instanceRecords(q)
+		if recs := m.instanceRecords(q); len(recs) > 0 {
+			return recs
+		}
+		return []dns.RR{m.instanceNSEC()}
 	case m.HostName:
-		if q.Qtype == dns.TypeA || q.Qtype == dns.TypeAAAA {
-			return m.instanceRecords(q)
+		var recs []dns.RR
+		switch q.Qtype {
+		case dns.TypeANY:
+			recs = m.hostRecords()
+		case dns.TypeA, dns.TypeAAAA:
+			recs = m.instanceRecords(q)
 		}
-		fallthrough
+		if len(recs) > 0 {
+			return recs
+		}
+		return []dns.RR{m.hostNSEC()}
 	default:
+		if m.isSubtypeAddr(q.Name) {
+			return m.serviceRecords(q)
+		}
 		return nil
 	}
 }
 
+// AdditionalRecords returns the records to add to the answers to a DNS
+// question: the SRV, TXT and address records of the instance for the service
+// PTR record, the address records of the host for the SRV record, and the
+// addresses of the other family for A and AAAA records. The NSEC records of
+// the instance and host names are added too, so that queriers know not to
+// wait for any other types.
+func (m *MDNSService) AdditionalRecords(q dns.Question) []dns.RR {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	name := q.Name
+	if m.isSubtypeAddr(name) {
+		// Subtype PTR records point to the instance like the service's
+		name = m.serviceAddr
+	}
+	switch name {
+	case m.serviceAddr:
+		switch q.Qtype {
+		case dns.TypeANY, dns.TypePTR:
+			recs := m.instanceRecords(dns.Question{Name: m.instanceAddr, Qtype: dns.TypeANY})
+			recs = append(recs, m.hostRecords()...)
+			return append(recs, m.instanceNSEC(), m.hostNSEC())
+		}
+	case m.instanceAddr:
+		switch q.Qtype {
+		case dns.TypeANY, dns.TypeSRV:
+			return append(m.hostRecords(), m.hostNSEC())
+		}
+	case m.HostName:
+		switch q.Qtype {
+		case dns.TypeA:
+			recs := m.instanceRecords(dns.Question{Name: m.HostName, Qtype: dns.TypeAAAA})
+			return append(recs, m.hostNSEC())
+		case dns.TypeAAAA:
+			recs := m.instanceRecords(dns.Question{Name: m.HostName, Qtype: dns.TypeA})
+			return append(recs, m.hostNSEC())
+		}
+	}
+	return nil
+}
+
+// PublishedRecords returns every record the service publishes.
+func (m *MDNSService) PublishedRecords() []dns.RR {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.publishedRecords()
+}
+
+func (m *MDNSService) publishedRecords() []dns.RR {
+	recs := m.serviceEnum(dns.Question{Name: m.enumAddr, Qtype: dns.TypePTR})
+	recs = append(recs, m.serviceRecords(dns.Question{Name: m.serviceAddr, Qtype: dns.TypePTR})...)
+	for _, subtype := range m.Subtypes {
+		recs = append(recs, m.serviceRecords(dns.Question{Name: m.subtypeAddr(subtype), Qtype: dns.TypePTR})...)
+	}
+	recs = append(recs, m.instanceRecords(dns.Question{Name: m.instanceAddr, Qtype: dns.TypeANY})...)
+	return append(recs, m.hostRecords()...)
+}
+
+// subtypeAddr returns the name of one of the service's subtypes, as per
+// section 7.1 of RFC 6763
+func (m *MDNSService) subtypeAddr(subtype string) string {
+	return fmt.Sprintf("%s._sub.%s", trimDot(subtype), m.serviceAddr)
+}
+
+// isSubtypeAddr checks if name is the name of one of the service's subtypes
+func (m *MDNSService) isSubtypeAddr(name string) bool {
+	return slices.ContainsFunc(m.Subtypes, func(subtype string) bool {
+		return m.subtypeAddr(subtype) == name
+	})
+}
+
+// instanceNSEC returns the NSEC record listing the types of the instance name
+func (m *MDNSService) instanceNSEC() dns.RR {
+	return nsec(m.instanceAddr, dns.TypeSRV, dns.TypeTXT)
+}
+
+// hostNSEC returns the NSEC record listing the types of the host name
+func (m *MDNSService) hostNSEC() dns.RR {
+	return nsec(m.HostName, recordTypes(m.hostRecords())...)
+}
+
+// recordTypes returns the distinct types of the given records
+func recordTypes(records []dns.RR) []uint16 {
+	var types []uint16
+	for _, rr := range records {
+		if !slices.Contains(types, rr.Header().Rrtype) {
+			types = append(types, rr.Header().Rrtype)
+		}
+	}
+	return types
+}
+
+// nsec returns an NSEC record asserting that name has no records of any type
+// other than the given ones. "The 'Next Domain Name' field contains the
+// record's own name", as per section 6.1 of RFC 6762.
+func nsec(name string, types ...uint16) dns.RR {
+	slices.Sort(types)
+	return &dns.NSEC{
+		Hdr: dns.RR_Header{
+			Name:   name,
+			Rrtype: dns.TypeNSEC,
+			Class:  dns.ClassINET | cacheFlush,
+			Ttl:    defaultTTL,
+		},
+		NextDomain: name,
+		TypeBitMap: types,
+	}
+}
+
+// hostRecords returns the A and AAAA records of the host
+func (m *MDNSService) hostRecords() []dns.RR {
+	recs := m.instanceRecords(dns.Question{Name: m.HostName, Qtype: dns.TypeA})
+	return append(recs, m.instanceRecords(dns.Question{Name: m.HostName, Qtype: dns.TypeAAAA})...)
+}
+
 func (m *MDNSService) serviceEnum(q dns.Question) []dns.RR {
 	switch q.Qtype {
 	case dns.TypeANY:
@@ -191,16 +567,9 @@ func (m *MDNSService) serviceRecords(q dns.Question) []dns.RR {
 			},
 			Ptr: m.instanceAddr,
 		}
-		servRec := []dns.RR{rr}
-
-		// Get the instance records
-		instRecs := m.instanceRecords(dns.Question{
-			Name:  m.instanceAddr,
-			Qtype: dns.TypeANY,
-		})
 
-		// Return the service record with the instance records
-		return append(servRec, instRecs...)
+		// The instance records go in the additional section
+		return []dns.RR{rr}
 	default:
 		return nil
 	}
@@ -210,7 +579,7 @@ func (m *MDNSService) serviceRecords(q dns.Question) []dns.RR {
 func (m *MDNSService) instanceRecords(q dns.Question) []dns.RR {
 	switch q.Qtype {
 	case dns.TypeANY:
-		// Get the SRV, which includes A and AAAA
+		// Get the SRV
 		recs := m.instanceRecords(dns.Question{
 			Name:  m.instanceAddr,
 			Qtype: dns.TypeSRV,
@@ -223,26 +592,62 @@ func (m *MDNSService) instanceRecords(q dns.Question) []dns.RR {
 		})...)
 		return recs
 
-	case dns.TypeA:
-		var rr []dns.RR
-		for _, ip := range m.IPs {
+	case dns.TypeA, dns.TypeAAAA:
+		return addressRecords(m.HostName, m.IPs, q.Qtype)
+
+	case dns.TypeSRV:
+		// Create the SRV Record
+		srv := &dns.SRV{
+			Hdr: dns.RR_Header{
+				Name:   q.Name,
+				Rrtype: dns.TypeSRV,
+				Class:  dns.ClassINET | cacheFlush,
+				Ttl:    defaultTTL,
+			},
+			Priority: 10,
+			Weight:   1,
+			Port:     uint16(m.Port),
+			Target:   m.HostName,
+		}
+
+		// The A and AAAA records go in the additional section
+		return []dns.RR{srv}
+
+	case dns.TypeTXT:
+		txt := &dns.TXT{
+			Hdr: dns.RR_Header{
+				Name:   q.Name,
+				Rrtype: dns.TypeTXT,
+				Class:  dns.ClassINET | cacheFlush,
+				Ttl:    defaultTTL,
+			},
+			Txt: m.TXT,
+		}
+		return []dns.RR{txt}
+	}
+	return nil
+}
+
+// addressRecords returns the A or AAAA records of a host with the given
+// addresses, depending on qtype
+func addressRecords(hostName string, ips []net.IP, qtype uint16) []dns.RR {
+	var rr []dns.RR
+	for _, ip := range ips {
+		switch qtype {
+		case dns.TypeA:
 			if ip4 := ip.To4(); ip4 != nil {
 				rr = append(rr, &dns.A{
 					Hdr: dns.RR_Header{
-						Name:   m.HostName,
+						Name:   hostName,
 						Rrtype: dns.TypeA,
-						Class:  dns.ClassINET,
+						Class:  dns.ClassINET | cacheFlush,
 						Ttl:    defaultTTL,
 					},
 					A: ip4,
 				})
 			}
-		}
-		return rr
 
-	case dns.TypeAAAA:
-		var rr []dns.RR
-		for _, ip := range m.IPs {
+		case dns.TypeAAAA:
 			if ip.To4() != nil {
 				// TODO(reddaly): IPv4 addresses could be encoded in IPv6 format and
 				// putinto AAAA records, but the current logic puts ipv4-encodable
@@ -254,57 +659,15 @@ func (m *MDNSService) instanceRecords(q dns.Question) []dns.RR {
 			if ip16 := ip.To16(); ip16 != nil {
 				rr = append(rr, &dns.AAAA{
 					Hdr: dns.RR_Header{
-						Name:   m.HostName,
+						Name:   hostName,
 						Rrtype: dns.TypeAAAA,
-						Class:  dns.ClassINET,
+						Class:  dns.ClassINET | cacheFlush,
 						Ttl:    defaultTTL,
 					},
 					AAAA: ip16,
 				})
 			}
 		}
-		return rr
-
-	case dns.TypeSRV:
-		// Create the SRV Record
-		srv := &dns.SRV{
-			Hdr: dns.RR_Header{
-				Name:   q.Name,
-				Rrtype: dns.TypeSRV,
-				Class:  dns.ClassINET,
-				Ttl:    defaultTTL,
-			},
-			Priority: 10,
-			Weight:   1,
-			Port:     uint16(m.Port),
-			Target:   m.HostName,
-		}
-		recs := []dns.RR{srv}
-
-		// Add the A record
-		recs = append(recs, m.instanceRecords(dns.Question{
-			Name:  m.instanceAddr,
-			Qtype: dns.TypeA,
-		})...)
-
-		// Add the AAAA record
-		recs = append(recs, m.instanceRecords(dns.Question{
-			Name:  m.instanceAddr,
-			Qtype: dns.TypeAAAA,
-		})...)
-		return recs
-
-	case dns.TypeTXT:
-		txt := &dns.TXT{
-			Hdr: dns.RR_Header{
-				Name:   q.Name,
-				Rrtype: dns.TypeTXT,
-				Class:  dns.ClassINET,
-				Ttl:    defaultTTL,
-			},
-			Txt: m.TXT,
-		}
-		return []dns.RR{txt}
 	}
-	return nil
+	return rr
 }
@@ -83,7 +83,7 @@ func TestMDNSService_ServiceAddr(t *testing.T) {
 		Qtype: dns.TypeANY,
 	}
 	recs := s.Records(q)
-	if got, want := len(recs), 5; got != want {
+	if got, want := len(recs), 1; got != want {
 		t.Fatalf("got %d records, want %d: %v", got, want, recs)
 	}
 
@@ -93,23 +93,36 @@ func TestMDNSService_ServiceAddr(t *testing.T) {
 		t.Fatalf("bad PTR record %v: got %v, want %v", ptr, got, want)
 	}
 
-	if _, ok := recs[1].(*dns.SRV); !ok {
-		t.Errorf("recs[1] should be SRV record, got: %v, all reccords: %v", recs[1], recs)
+	extra := s.AdditionalRecords(q)
+	if got, want := len(extra), 6; got != want {
+		t.Fatalf("got %d additional records, want %d: %v", got, want, extra)
 	}
-	if _, ok := recs[2].(*dns.A); !ok {
-		t.Errorf("recs[2] should be A record, got: %v, all records: %v", recs[2], recs)
+	if _, ok := extra[0].(*dns.SRV); !ok {
+		t.Errorf("extra[0] should be SRV record, got: %v, all records: %v", extra[0], extra)
 	}
-	if _, ok := recs[3].(*dns.AAAA); !ok {
-		t.Errorf("recs[3] should be AAAA record, got: %v, all records: %v", recs[3], recs)
+	if _, ok := extra[1].(*dns.TXT); !ok {
+		t.Errorf("extra[1] should be TXT record, got: %v, all records: %v", extra[1], extra)
 	}
-	if _, ok := recs[4].(*dns.TXT); !ok {
-		t.Errorf("recs[4] should be TXT record, got: %v, all records: %v", recs[4], recs)
+	if _, ok := extra[2].(*dns.A); !ok {
+		t.Errorf("extra[2] should be A record, got: %v, all records: %v", extra[2], extra)
+	}
+	if _, ok := extra[3].(*dns.AAAA); !ok {
+		t.Errorf("extra[3] should be AAAA record, got: %v, all records: %v", extra[3], extra)
+	}
+	if nsec, ok := extra[4].(*dns.NSEC); !ok || nsec.Hdr.Name != s.instanceAddr {
+		t.Errorf("extra[4] should be NSEC record of the instance, got: %v, all records: %v", extra[4], extra)
+	}
+	if nsec, ok := extra[5].(*dns.NSEC); !ok || nsec.Hdr.Name != s.HostName {
+		t.Errorf("extra[5] should be NSEC record of the host, got: %v, all records: %v", extra[5], extra)
 	}
 
 	q.Qtype = dns.TypePTR
 	if recs2 := s.Records(q); !reflect.DeepEqual(recs, recs2) {
 		t.Fatalf("PTR question should return same result as ANY question: ANY => %v, PTR => %v", recs, recs2)
 	}
+	if extra2 := s.AdditionalRecords(q); !reflect.DeepEqual(extra, extra2) {
+		t.Fatalf("PTR question should return same result as ANY question: ANY => %v, PTR => %v", extra, extra2)
+	}
 }
 
 func TestMDNSService_InstanceAddr_ANY(t *testing.T) {
@@ -119,20 +132,28 @@ func TestMDNSService_InstanceAddr_ANY(t *testing.T) {
 		Qtype: dns.TypeANY,
 	}
 	recs := s.Records(q)
-	if len(recs) != 4 {
+	if len(recs) != 2 {
 		t.Fatalf("bad: %v", recs)
 	}
 	if _, ok := recs[0].(*dns.SRV); !ok {
 		t.Fatalf("bad: %v", recs[0])
 	}
-	if _, ok := recs[1].(*dns.A); !ok {
+	if _, ok := recs[1].(*dns.TXT); !ok {
 		t.Fatalf("bad: %v", recs[1])
 	}
-	if _, ok := recs[2].(*dns.AAAA); !ok {
-		t.Fatalf("bad: %v", recs[2])
+
+	extra := s.AdditionalRecords(q)
+	if len(extra) != 3 {
+		t.Fatalf("bad: %v", extra)
+	}
+	if _, ok := extra[0].(*dns.A); !ok {
+		t.Fatalf("bad: %v", extra[0])
 	}
-	if _, ok := recs[3].(*dns.TXT); !ok {
-		t.Fatalf("bad: %v", recs[3])
+	if _, ok := extra[1].(*dns.AAAA); !ok {
+		t.Fatalf("bad: %v", extra[1])
+	}
+	if _, ok := extra[2].(*dns.NSEC); !ok {
+		t.Fatalf("bad: %v", extra[2])
 	}
 }
 
@@ -143,23 +164,30 @@ func TestMDNSService_InstanceAddr_SRV(t *testing.T) {
 		Qtype: dns.TypeSRV,
 	}
 	recs := s.Records(q)
-	if len(recs) != 3 {
+	if len(recs) != 1 {
 		t.Fatalf("bad: %v", recs)
 	}
 	srv, ok := recs[0].(*dns.SRV)
 	if !ok {
 		t.Fatalf("bad: %v", recs[0])
 	}
-	if _, ok := recs[1].(*dns.A); !ok {
-		t.Fatalf("bad: %v", recs[1])
-	}
-	if _, ok := recs[2].(*dns.AAAA); !ok {
-		t.Fatalf("bad: %v", recs[2])
-	}
-
 	if srv.Port != uint16(s.Port) {
 		t.Fatalf("bad: %v", recs[0])
 	}
+
+	extra := s.AdditionalRecords(q)
+	if len(extra) != 3 {
+		t.Fatalf("bad: %v", extra)
+	}
+	if _, ok := extra[0].(*dns.A); !ok {
+		t.Fatalf("bad: %v", extra[0])
+	}
+	if _, ok := extra[1].(*dns.AAAA); !ok {
+		t.Fatalf("bad: %v", extra[1])
+	}
+	if _, ok := extra[2].(*dns.NSEC); !ok {
+		t.Fatalf("bad: %v", extra[2])
+	}
 }
 
 func TestMDNSService_InstanceAddr_A(t *testing.T) {
@@ -235,7 +263,7 @@ func TestMDNSService_HostNameQuery(t *testing.T) {
 				Hdr: dns.RR_Header{
 					Name:   "testhost.",
 					Rrtype: dns.TypeA,
-					Class:  dns.ClassINET,
+					Class:  dns.ClassINET | cacheFlush,
 					Ttl:    120,
 				},
 				A: net.IP([]byte{192, 168, 0, 42}),
@@ -247,7 +275,27 @@ func TestMDNSService_HostNameQuery(t *testing.T) {
 				Hdr: dns.RR_Header{
 					Name:   "testhost.",
 					Rrtype: dns.TypeAAAA,
-					Class:  dns.ClassINET,
+					Class:  dns.ClassINET | cacheFlush,
+					Ttl:    120,
+				},
+				AAAA: net.ParseIP("2620:0:1000:1900:b0c2:d0b2:c411:18bc"),
+			}},
+		},
+		{
+			dns.Question{Name: "testhost.", Qtype: dns.TypeANY},
+			[]dns.RR{&dns.A{
+				Hdr: dns.RR_Header{
+					Name:   "testhost.",
+					Rrtype: dns.TypeA,
+					Class:  dns.ClassINET | cacheFlush,
+					Ttl:    120,
+				},
+				A: net.IP([]byte{192, 168, 0, 42}),
+			}, &dns.AAAA{
+				Hdr: dns.RR_Header{
+					Name:   "testhost.",
+					Rrtype: dns.TypeAAAA,
+					Class:  dns.ClassINET | cacheFlush,
 					Ttl:    120,
 				},
 				AAAA: net.ParseIP("2620:0:1000:1900:b0c2:d0b2:c411:18bc"),
@@ -260,6 +308,88 @@ func TestMDNSService_HostNameQuery(t *testing.T) {
 	}
 }
 
+func TestMDNSService_NSEC(t *testing.T) {
+	s := makeService(t)
+	if err := s.SetIPs([]net.IP{net.IP([]byte{192, 168, 0, 42})}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for _, test := range []struct {
+		q     dns.Question
+		types []uint16
+	}{
+		{dns.Question{Name: "testhost.", Qtype: dns.TypeAAAA}, []uint16{dns.TypeA}},
+		{dns.Question{Name: "testhost.", Qtype: dns.TypeMX}, []uint16{dns.TypeA}},
+		{dns.Question{Name: "hostname._http._tcp.local.", Qtype: dns.TypeMX}, []uint16{dns.TypeTXT, dns.TypeSRV}},
+	} {
+		recs := s.Records(test.q)
+		if len(recs) != 1 {
+			t.Fatalf("%v: expected a single NSEC record, got: %v", test.q, recs)
+		}
+		nsec, ok := recs[0].(*dns.NSEC)
+		if !ok || nsec.NextDomain != test.q.Name || !reflect.DeepEqual(nsec.TypeBitMap, test.types) {
+			t.Fatalf("%v: bad NSEC record: %v", test.q, recs[0])
+		}
+	}
+
+	// The A records go with the NSEC record in answer to an AAAA question
+	extra := s.AdditionalRecords(dns.Question{Name: "testhost.", Qtype: dns.TypeAAAA})
+	if len(extra) != 2 {
+		t.Fatalf("bad: %v", extra)
+	}
+	if _, ok := extra[0].(*dns.A); !ok {
+		t.Fatalf("bad: %v", extra[0])
+	}
+
+	// Shared names are not owned by the service, so there is no NSEC record
+	if recs := s.Records(dns.Question{Name: "_http._tcp.local.", Qtype: dns.TypeSRV}); len(recs) != 0 {
+		t.Fatalf("bad: %v", recs)
+	}
+}
+
+func TestMDNSService_CacheFlush(t *testing.T) {
+	s := makeService(t)
+	for _, rr := range s.PublishedRecords() {
+		_, shared := rr.(*dns.PTR)
+		if got := rr.Header().Class&cacheFlush != 0; got == shared {
+			t.Fatalf("bad cache-flush bit: %v", rr)
+		}
+	}
+}
+
+func TestMDNSService_Subtypes(t *testing.T) {
+	s := makeService(t)
+	s.Subtypes = []string{"_printer"}
+
+	q := dns.Question{Name: "_printer._sub._http._tcp.local.", Qtype: dns.TypePTR}
+	recs := s.Records(q)
+	if len(recs) != 1 {
+		t.Fatalf("bad: %v", recs)
+	}
+	if ptr, ok := recs[0].(*dns.PTR); !ok || ptr.Hdr.Name != q.Name || ptr.Ptr != s.instanceAddr {
+		t.Fatalf("bad PTR record: %v", recs[0])
+	}
+	if extra := s.AdditionalRecords(q); len(extra) != 6 {
+		t.Fatalf("expected the instance records as additional records: %v", extra)
+	}
+	if recs := s.Records(dns.Question{Name: "_scanner._sub._http._tcp.local.", Qtype: dns.TypePTR}); len(recs) != 0 {
+		t.Fatalf("bad: %v", recs)
+	}
+
+	var published bool
+	for _, rr := range s.PublishedRecords() {
+		published = published || rr.Header().Name == q.Name
+	}
+	if !published {
+		t.Fatalf("expected the subtype PTR record to be published")
+	}
+
+	s.SetSubtypes(nil)
+	if recs := s.Records(q); len(recs) != 0 {
+		t.Fatalf("bad: %v", recs)
+	}
+}
+
 func TestMDNSService_serviceEnum_PTR(t *testing.T) {
 	s := makeService(t)
 	q := dns.Question{
@@ -276,3 +406,100 @@ func TestMDNSService_serviceEnum_PTR(t *testing.T) {
 		t.Fatalf("bad PTR record %v: got %v, want %v", ptr, got, want)
 	}
 }
+
+func TestMDNSService_Rename(t *testing.T) {
+	s := makeService(t)
+	for _, test := range []struct {
+		name, want string
+	}{
+		{"hostname._http._tcp.local.", "hostname (2)._http._tcp.local."},
+		{"hostname (2)._http._tcp.local.", "hostname (3)._http._tcp.local."},
+		{"testhost.", "testhost-2."},
+		{"testhost-2.", "testhost-3."},
+	} {
+		got, err := s.Rename(test.name)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if got != test.want {
+			t.Fatalf("Rename(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+
+	recs := s.Records(dns.Question{Name: "hostname (3)._http._tcp.local.", Qtype: dns.TypeSRV})
+	if len(recs) == 0 || recs[0].(*dns.SRV).Target != "testhost-3." {
+		t.Fatalf("bad: %v", recs)
+	}
+	if _, err := s.Rename("random."); err == nil {
+		t.Fatalf("expected an error renaming a name the service doesn't own")
+	}
+}
+
+func TestNextHostName(t *testing.T) {
+	for _, test := range []struct {
+		name, want string
+	}{
+		{"myhost.local.", "myhost-2.local."},
+		{"myhost-2.local.", "myhost-3.local."},
+		{"my-host.local.", "my-host-2.local."},
+		{"myhost-1.local.", "myhost-1-2.local."},
+	} {
+		if got := nextHostName(test.name); got != test.want {
+			t.Errorf("nextHostName(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestMDNSService_Update(t *testing.T) {
+	s := makeService(t)
+	var changed, removed []dns.RR
+	stop := s.Watch(func(c, r []dns.RR) {
+		changed, removed = c, r
+	})
+
+	// Records may be read while the service is updated
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			s.Records(dns.Question{Name: "_http._tcp.local.", Qtype: dns.TypePTR})
+		}
+	}()
+	s.SetTXT([]string{"Updated web server"})
+	<-done
+
+	if len(changed) != 1 || len(removed) != 1 {
+		t.Fatalf("expected one TXT record changed and one removed, got: %v, %v", changed, removed)
+	}
+	if txt, ok := changed[0].(*dns.TXT); !ok || !reflect.DeepEqual(txt.Txt, []string{"Updated web server"}) {
+		t.Fatalf("bad changed record: %v", changed[0])
+	}
+	if txt, ok := removed[0].(*dns.TXT); !ok || !reflect.DeepEqual(txt.Txt, []string{"Local web server"}) {
+		t.Fatalf("bad removed record: %v", removed[0])
+	}
+
+	if err := s.SetIPs([]net.IP{net.IP([]byte{192, 168, 0, 42})}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(changed) != 0 || len(removed) != 1 {
+		t.Fatalf("expected one AAAA record removed, got: %v, %v", changed, removed)
+	}
+	if _, ok := removed[0].(*dns.AAAA); !ok {
+		t.Fatalf("bad removed record: %v", removed[0])
+	}
+
+	if err := s.SetPort(0); err == nil {
+		t.Fatalf("expected an error setting port 0")
+	}
+	stop()
+	changed, removed = nil, nil
+	if err := s.SetPort(8080); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if changed != nil || removed != nil {
+		t.Fatalf("expected no notification after stop, got: %v, %v", changed, removed)
+	}
+	if srv := s.Records(dns.Question{Name: s.instanceAddr, Qtype: dns.TypeSRV})[0].(*dns.SRV); srv.Port != 8080 {
+		t.Fatalf("bad SRV record: %v", srv)
+	}
+}